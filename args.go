@@ -0,0 +1,105 @@
+package gubot_gitlab
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// chat clients often replace quotes by typographic ones
+var quotesReplacer = strings.NewReplacer("“", "\"", "”", "\"", "‘", "'", "’", "'")
+
+// tokenizeCommand splits a chat message in arguments like a shell does,
+// arguments are separated by spaces unless they are in single or double
+// quotes and a backslash escapes the next character (except in single
+// quotes), e.g.: issue create group/project "my \"great\" title" --label=a,b
+// A single quote only starts a quoted argument at the beginning of an
+// argument and is kept as an apostrophe when it's never closed (e.g.: I'll
+// fix it, 'cause).
+func tokenizeCommand(message string) ([]string, error) {
+	message = quotesReplacer.Replace(message)
+	args, err := tokenize(message, true)
+	if err == errSingleQuoteNotClosed {
+		return tokenize(message, false)
+	}
+	return args, err
+}
+
+var errSingleQuoteNotClosed = errors.New("You forgot to close a quote in your command.")
+
+func tokenize(message string, singleQuotes bool) ([]string, error) {
+	args := make([]string, 0)
+	var current []rune
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, char := range message {
+		switch {
+		case escaped:
+			current = append(current, char)
+			escaped = false
+		case char == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if char == quote {
+				quote = 0
+				continue
+			}
+			current = append(current, char)
+		case char == '"' || (char == '\'' && singleQuotes && !inArg):
+			quote = char
+			inArg = true
+		case unicode.IsSpace(char):
+			if inArg {
+				args = append(args, string(current))
+				current = current[:0]
+				inArg = false
+			}
+		default:
+			current = append(current, char)
+			inArg = true
+		}
+	}
+	if quote == '\'' {
+		return nil, errSingleQuoteNotClosed
+	}
+	if quote != 0 {
+		return nil, errors.New("You forgot to close a quote in your command.")
+	}
+	if escaped {
+		current = append(current, '\\')
+	}
+	if inArg {
+		args = append(args, string(current))
+	}
+	return args, nil
+}
+
+// parseFlags splits command arguments in positional arguments and flags,
+// flags can be given anywhere as --name or --name=value, flags listed in
+// valueFlags also accept their value as next argument (--name value).
+// A flag without value is set to true.
+func parseFlags(args []string, valueFlags ...string) ([]string, map[string]string) {
+	positional := make([]string, 0)
+	flags := make(map[string]string)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "--") || arg == "--" {
+			positional = append(positional, arg)
+			continue
+		}
+		nameValue := strings.SplitN(strings.TrimPrefix(arg, "--"), "=", 2)
+		if len(nameValue) == 2 {
+			flags[nameValue[0]] = nameValue[1]
+			continue
+		}
+		if inSlice(valueFlags, nameValue[0]) && i + 1 < len(args) {
+			flags[nameValue[0]] = args[i + 1]
+			i++
+			continue
+		}
+		flags[nameValue[0]] = "true"
+	}
+	return positional, flags
+}
@@ -0,0 +1,54 @@
+package gubot_gitlab
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenizeCommand(t *testing.T) {
+	tests := []struct {
+		message string
+		args    []string
+		invalid bool
+	}{
+		{message: "mr see group/project!12", args: []string{"mr", "see", "group/project!12"}},
+		{message: "  spaces   around  ", args: []string{"spaces", "around"}},
+		{message: `issue create group/project "my \"great\" title"`, args: []string{"issue", "create", "group/project", `my "great" title`}},
+		{message: `note 'single quoted \ text'`, args: []string{"note", `single quoted \ text`}},
+		{message: "“typographic quotes”", args: []string{"typographic quotes"}},
+		{message: "can't merge yet", args: []string{"can't", "merge", "yet"}},
+		{message: "I’ll fix it", args: []string{"I'll", "fix", "it"}},
+		{message: "'cause it fails", args: []string{"'cause", "it", "fails"}},
+		{message: `it's "quoted text"`, args: []string{"it's", "quoted text"}},
+		{message: `escaped\ space`, args: []string{"escaped space"}},
+		{message: `""`, args: []string{""}},
+		{message: `trailing\`, args: []string{`trailing\`}},
+		{message: `unclosed "quote`, invalid: true},
+	}
+	for _, test := range tests {
+		args, err := tokenizeCommand(test.message)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("tokenizeCommand(%q) should fail", test.message)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("tokenizeCommand(%q) failed: %s", test.message, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(args, test.args) {
+			t.Errorf("tokenizeCommand(%q) = %q, want %q", test.message, args, test.args)
+		}
+	}
+}
+func TestParseFlags(t *testing.T) {
+	positional, flags := parseFlags([]string{"group/project", "--ref", "master", "--days=7", "--squash", "title"}, "ref")
+	if !reflect.DeepEqual(positional, []string{"group/project", "title"}) {
+		t.Errorf("positional arguments = %q", positional)
+	}
+	expected := map[string]string{"ref": "master", "days": "7", "squash": "true"}
+	if !reflect.DeepEqual(flags, expected) {
+		t.Errorf("flags = %q, want %q", flags, expected)
+	}
+}
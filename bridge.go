@@ -0,0 +1,249 @@
+package gubot_gitlab
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ArthurHlt/gubot/robot"
+	"github.com/urfave/cli"
+	"github.com/xanzy/go-gitlab"
+	"strings"
+	"time"
+)
+
+const (
+	BRIDGE_CRON = "* * * * *"
+	// BRIDGE_CHAT_PREFIX starts every note relayed in chat, these messages
+	// are not sent back to gitlab as every messages sent by the robot
+	BRIDGE_CHAT_PREFIX = "[gitlab] "
+	// BRIDGE_NOTE_MARKER ends every chat message posted as a note, these
+	// notes are not sent back to chat
+	BRIDGE_NOTE_MARKER = "<!-- bridged from chat -->"
+	BRIDGE_NOTE_SIZE = 1000
+	BRIDGE_NOTE_LINES = 30
+)
+
+type noteEvent struct {
+	ProjectID        int `json:"project_id"`
+	User             struct {
+		Username string `json:"username"`
+	} `json:"user"`
+	ObjectAttributes struct {
+		Note         string `json:"note"`
+		NoteableType string `json:"noteable_type"`
+		System       bool   `json:"system"`
+		URL          string `json:"url"`
+	} `json:"object_attributes"`
+	MergeRequest     struct {
+		IID int `json:"iid"`
+	} `json:"merge_request"`
+	Issue            struct {
+		IID int `json:"iid"`
+	} `json:"issue"`
+}
+
+func (g GitlabApp) cmdMergeRequestBridge(envelop robot.Envelop, c *cli.Context) error {
+	ref, err := g.resolveRef(c.Args().First(), MERGE_REQUEST_EVENT_NAME)
+	if err != nil {
+		fmt.Fprint(c.App.Writer, err.Error())
+		return nil
+	}
+	_, err = g.actionOptions(*ref, envelop.User.Name, gitlab.ReporterPermissions)
+	if err != nil {
+		fmt.Fprint(c.App.Writer, err.Error())
+		return nil
+	}
+	bridge, err := g.createBridge(*ref, envelop.ChannelName)
+	if err != nil {
+		fmt.Fprintf(c.App.Writer, "Sorry I can't bridge merge request %s: %s", ref, err.Error())
+		return nil
+	}
+	fmt.Fprintf(c.App.Writer, "Merge request %s is now bridged, comments on gitlab are relayed in its thread and replies in the thread are posted on gitlab.", ref)
+	g.enableNoteEvents(bridge.ProjectID)
+	return nil
+}
+func (g GitlabApp) cmdMergeRequestUnbridge(envelop robot.Envelop, c *cli.Context) error {
+	ref, err := g.resolveRef(c.Args().First(), MERGE_REQUEST_EVENT_NAME)
+	if err != nil {
+		fmt.Fprint(c.App.Writer, err.Error())
+		return nil
+	}
+	var bridge GitlabBridge
+	robot.Store().Where(&GitlabBridge{
+		ProjectID: ref.ProjectID,
+		ObjectIid: ref.ObjectIid,
+		ChannelName: envelop.ChannelName,
+	}).First(&bridge)
+	if bridge.ID == 0 {
+		fmt.Fprintf(c.App.Writer, "Merge request %s is not bridged in this channel.", ref)
+		return nil
+	}
+	robot.Store().Unscoped().Delete(&bridge)
+	fmt.Fprintf(c.App.Writer, "Bridge of merge request %s has been removed.", ref)
+	return nil
+}
+
+// createBridge links a merge request to the thread of its notification when
+// it was sent in the channel or to a new thread.
+func (g GitlabApp) createBridge(ref gitlabRef, channelName string) (*GitlabBridge, error) {
+	renderer, ok := g.renderer.(threadedRenderer)
+	if !ok {
+		return nil, errors.New("a chat api token is required to use threads.")
+	}
+	if _, ok := g.renderer.(threadReader); !ok {
+		return nil, errors.New("the chat renderer can't read threads.")
+	}
+	bridge := &GitlabBridge{
+		ProjectID: ref.ProjectID,
+		ObjectIid: ref.ObjectIid,
+		ChannelName: channelName,
+	}
+	var count int
+	robot.Store().Model(&GitlabBridge{}).Where(bridge).Count(&count)
+	if count > 0 {
+		return nil, errors.New("it's already bridged in this channel.")
+	}
+	bridge.ProjectPath = ref.ProjectPath
+	bridge.WebUrl = ref.WebUrl
+	bridge.LastSyncedAt = time.Now().UnixNano() / int64(time.Millisecond)
+	if ref.Notif != nil && ref.Notif.ChatMessageId != "" && ref.Notif.ChannelName == channelName {
+		bridge.ChatChannelId = ref.Notif.ChatChannelId
+		bridge.ChatThreadId = ref.Notif.ChatMessageId
+	} else {
+		channelId, messageId, err := renderer.SendRoot(channelName, "", fmt.Sprintf(
+			"Review of merge request [%s](%s) is bridged with gitlab, replies in this thread are posted on the merge request.",
+			ref,
+			ref.WebUrl,
+		), nil)
+		if err != nil {
+			return nil, err
+		}
+		bridge.ChatChannelId = channelId
+		bridge.ChatThreadId = messageId
+	}
+	robot.Store().Create(bridge)
+	return bridge, nil
+}
+
+// enableNoteEvents makes sure the webhook of the project sends comments,
+// hooks created before bridges didn't ask for them.
+func (g GitlabApp) enableNoteEvents(projectID int) {
+	trueBool := true
+	err := g.enableHookEvents(projectID, func(hook *gitlab.ProjectHook) bool {
+		return hook.NoteEvents
+	}, &gitlab.EditProjectHookOptions{
+		NoteEvents: &trueBool,
+	})
+	if err != nil {
+		robot.Logger().Error("Error when enabling comments on hook of project %d: %s", projectID, err.Error())
+	}
+}
+
+// relayNote sends a comment made on gitlab in threads of bridges of its
+// merge request.
+func (g GitlabApp) relayNote(webhook []byte) {
+	var event noteEvent
+	json.Unmarshal(webhook, &event)
+	note := event.ObjectAttributes
+	if note.NoteableType != "MergeRequest" || note.System || strings.Contains(note.Note, BRIDGE_NOTE_MARKER) {
+		return
+	}
+	renderer, ok := g.renderer.(threadedRenderer)
+	if !ok {
+		return
+	}
+	var bridges []GitlabBridge
+	robot.Store().Where(&GitlabBridge{
+		ProjectID: event.ProjectID,
+		ObjectIid: event.MergeRequest.IID,
+	}).Find(&bridges)
+	message := fmt.Sprintf(
+		"%s@%s commented ([see](%s)):\n%s",
+		BRIDGE_CHAT_PREFIX,
+		g.retrieveChatUser(event.User.Username),
+		note.URL,
+		quote(truncateText(note.Note, BRIDGE_NOTE_SIZE, BRIDGE_NOTE_LINES)),
+	)
+	for _, bridge := range bridges {
+		err := renderer.Reply(bridge.ChatChannelId, bridge.ChatThreadId, message)
+		if err != nil {
+			robot.Logger().Error("Error when relaying comment in thread: %s", err.Error())
+		}
+	}
+}
+
+// syncBridges posts on gitlab the replies made in bridged threads since last
+// synchronization.
+func (g GitlabApp) syncBridges() {
+	reader, ok := g.renderer.(threadReader)
+	if !ok {
+		return
+	}
+	var bridges []GitlabBridge
+	robot.Store().Find(&bridges)
+	for _, bridge := range bridges {
+		since := time.Unix(0, bridge.LastSyncedAt * int64(time.Millisecond))
+		messages, err := reader.Thread(bridge.ChatChannelId, bridge.ChatThreadId, since)
+		if err != nil {
+			robot.Logger().Error("Error when reading bridged thread of %s: %s", bridge.WebUrl, err.Error())
+			continue
+		}
+		// root of the thread is sent by the robot
+		for _, message := range messages {
+			createdAt := message.CreatedAt.UnixNano() / int64(time.Millisecond)
+			if createdAt <= bridge.LastSyncedAt || message.FromBot || strings.HasPrefix(message.Message, BRIDGE_CHAT_PREFIX) {
+				continue
+			}
+			err = g.postBridgedMessage(bridge, message)
+			if err != nil {
+				// message is skipped to not block next ones of the thread
+				robot.Logger().Error("Error when posting bridged message on %s: %s", bridge.WebUrl, err.Error())
+				if renderer, ok := g.renderer.(threadedRenderer); ok {
+					renderer.Reply(bridge.ChatChannelId, bridge.ChatThreadId, fmt.Sprintf(
+						"%sSorry I can't post the message of @%s on gitlab: %s",
+						BRIDGE_CHAT_PREFIX,
+						message.Username,
+						err.Error(),
+					))
+				}
+			}
+			bridge.LastSyncedAt = createdAt
+			robot.Store().Save(&bridge)
+		}
+	}
+}
+func (g GitlabApp) postBridgedMessage(bridge GitlabBridge, message chatMessage) error {
+	gitlabUsername := g.retrieveGitlabUserFromChat(message.Username)
+	body := chatMentionRegex.ReplaceAllStringFunc(message.Message, func(mention string) string {
+		return "@" + g.retrieveGitlabUserFromChat(strings.TrimPrefix(mention, "@"))
+	})
+	options := make([]gitlab.OptionFunc, 0)
+	if g.conf.GitlabActAsUser {
+		options = append(options, withSudo(gitlabUsername))
+	} else {
+		body = fmt.Sprintf("**@%s** from chat:\n\n%s", gitlabUsername, body)
+	}
+	ref := gitlabRef{
+		Type: MERGE_REQUEST_EVENT_NAME,
+		ProjectID: bridge.ProjectID,
+		ProjectPath: bridge.ProjectPath,
+		ObjectIid: bridge.ObjectIid,
+	}
+	return g.createNote(ref, body + "\n\n" + BRIDGE_NOTE_MARKER, options...)
+}
+
+// closeBridges removes bridges of a merged or closed merge request.
+func (g GitlabApp) closeBridges(projectID, mrIid int, state string) {
+	var bridges []GitlabBridge
+	robot.Store().Where(&GitlabBridge{
+		ProjectID: projectID,
+		ObjectIid: mrIid,
+	}).Find(&bridges)
+	renderer, ok := g.renderer.(threadedRenderer)
+	for _, bridge := range bridges {
+		if ok {
+			renderer.Reply(bridge.ChatChannelId, bridge.ChatThreadId, BRIDGE_CHAT_PREFIX + "Merge request " + state + ", bridge closed.")
+		}
+		robot.Store().Unscoped().Delete(&bridge)
+	}
+}
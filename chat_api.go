@@ -0,0 +1,393 @@
+package gubot_gitlab
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ArthurHlt/gubot/robot"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const SLACK_API_URL = "https://slack.com/api"
+
+// threadedRenderer is implemented by renderers able to give back the id of a
+// sent message to reply in its thread or update it later.
+type threadedRenderer interface {
+	chatRenderer
+	SendRoot(channelName, mentions, message string, card *ChatCard) (channelId string, messageId string, err error)
+	Reply(channelId, threadId, message string) error
+	Update(channelId, messageId, message string, card *ChatCard) error
+}
+
+// threadReader is implemented by renderers able to read back messages of a
+// thread, only messages sent after since are read when it's not zero.
+type threadReader interface {
+	Thread(channelId, threadId string, since time.Time) ([]chatMessage, error)
+}
+type chatMessage struct {
+	Username  string
+	Message   string
+	CreatedAt time.Time
+	// FromBot is true when the message was sent by the robot
+	FromBot   bool
+}
+
+// apiRenderer uses slack web api or mattermost api v4 to send messages.
+type apiRenderer struct {
+	apiUrl string
+	token  string
+	team   string
+	slack  bool
+	// identity is shared by copies of the renderer
+	identity *chatIdentity
+}
+
+// chatIdentity caches the chat user id of the robot, it doesn't change while
+// running.
+type chatIdentity struct {
+	mutex  sync.Mutex
+	userId string
+}
+
+func newApiRenderer(conf GitlabConfig) (chatRenderer, error) {
+	renderer := apiRenderer{
+		apiUrl: strings.TrimSuffix(conf.GitlabChatApiUrl, "/"),
+		token: conf.GitlabChatApiToken,
+		team: conf.GitlabChatTeam,
+		slack: conf.GitlabChatRenderer == RENDERER_SLACK,
+		identity: &chatIdentity{},
+	}
+	if renderer.slack && renderer.apiUrl == "" {
+		renderer.apiUrl = SLACK_API_URL
+	}
+	if !renderer.slack && (renderer.apiUrl == "" || renderer.team == "") {
+		return nil, errors.New("GitlabChatApiUrl and GitlabChatTeam conf parameters must be set to use mattermost api.")
+	}
+	return renderer, nil
+}
+func (r apiRenderer) Send(channelName, mentions, message string, card *ChatCard) error {
+	_, _, err := r.SendRoot(channelName, mentions, message, card)
+	return err
+}
+func (r apiRenderer) SendRoot(channelName, mentions, message string, card *ChatCard) (string, string, error) {
+	if r.slack {
+		return r.slackPost(channelName, "", mentions, message, card)
+	}
+	channelId, err := r.mattermostChannelId(channelName)
+	if err != nil {
+		return "", "", err
+	}
+	return r.mattermostPost(channelId, "", mentions, message, card)
+}
+func (r apiRenderer) Reply(channelId, threadId, message string) error {
+	var err error
+	if r.slack {
+		_, _, err = r.slackPost(channelId, threadId, "", message, nil)
+	} else {
+		_, _, err = r.mattermostPost(channelId, threadId, "", message, nil)
+	}
+	return err
+}
+func (r apiRenderer) Update(channelId, messageId, message string, card *ChatCard) error {
+	text, attachments := r.content("", message, card)
+	if r.slack {
+		var resp slackResponse
+		return r.call("POST", "/chat.update", map[string]interface{}{
+			"channel": channelId,
+			"ts": messageId,
+			"text": text,
+			"attachments": attachments,
+		}, &resp)
+	}
+	return r.call("PUT", "/api/v4/posts/" + messageId + "/patch", map[string]interface{}{
+		"message": text,
+		"props": map[string]interface{}{
+			"attachments": attachments,
+		},
+	}, nil)
+}
+
+// content returns the text and the attachments to send, without card the
+// markdown message is sent as text.
+func (r apiRenderer) content(mentions, message string, card *ChatCard) (string, []attachment) {
+	if card == nil {
+		if mentions != "" {
+			message = mentions + " : " + message
+		}
+		return formatForChat(message, r.slack), []attachment{}
+	}
+	return mentions, []attachment{newAttachment(message, card, r.slack)}
+}
+
+type slackResponse struct {
+	Ok      bool   `json:"ok"`
+	Error   string `json:"error"`
+	Channel string `json:"channel"`
+	Ts      string `json:"ts"`
+}
+
+func (r apiRenderer) slackPost(channel, threadTs, mentions, message string, card *ChatCard) (string, string, error) {
+	text, attachments := r.content(mentions, message, card)
+	payload := map[string]interface{}{
+		"channel": channel,
+		"text": text,
+		"attachments": attachments,
+		"link_names": true,
+	}
+	if threadTs != "" {
+		payload["thread_ts"] = threadTs
+	}
+	var resp slackResponse
+	err := r.call("POST", "/chat.postMessage", payload, &resp)
+	if err != nil {
+		return "", "", err
+	}
+	return resp.Channel, resp.Ts, nil
+}
+
+type mattermostPost struct {
+	Id        string `json:"id"`
+	ChannelId string `json:"channel_id"`
+}
+type mattermostThreadPost struct {
+	UserId   string `json:"user_id"`
+	RootId   string `json:"root_id"`
+	Message  string `json:"message"`
+	CreateAt int64  `json:"create_at"`
+}
+
+func (r apiRenderer) mattermostPost(channelId, rootId, mentions, message string, card *ChatCard) (string, string, error) {
+	text, attachments := r.content(mentions, message, card)
+	payload := map[string]interface{}{
+		"channel_id": channelId,
+		"message": text,
+		"props": map[string]interface{}{
+			"attachments": attachments,
+		},
+	}
+	if rootId != "" {
+		payload["root_id"] = rootId
+	}
+	var post mattermostPost
+	err := r.call("POST", "/api/v4/posts", payload, &post)
+	if err != nil {
+		return "", "", err
+	}
+	return post.ChannelId, post.Id, nil
+}
+func (r apiRenderer) mattermostChannelId(channelName string) (string, error) {
+	var channel struct {
+		Id string `json:"id"`
+	}
+	u := fmt.Sprintf(
+		"/api/v4/teams/name/%s/channels/name/%s",
+		url.PathEscape(r.team),
+		url.PathEscape(strings.TrimPrefix(channelName, "#")),
+	)
+	err := r.call("GET", u, nil, &channel)
+	if err != nil {
+		return "", err
+	}
+	return channel.Id, nil
+}
+func (r apiRenderer) call(method, path string, payload interface{}, result interface{}) error {
+	var body *bytes.Reader
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	} else {
+		body = bytes.NewReader([]byte{})
+	}
+	req, err := http.NewRequest(method, r.apiUrl + path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer " + r.token)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	resp, err := robot.HttpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("chat api %s %s responded with status %d", method, path, resp.StatusCode)
+	}
+	if result == nil {
+		return nil
+	}
+	err = json.NewDecoder(resp.Body).Decode(result)
+	if err != nil {
+		return err
+	}
+	if slackResp, ok := result.(*slackResponse); ok && !slackResp.Ok {
+		return errors.New("slack api error: " + slackResp.Error)
+	}
+	return nil
+}
+// botUserId returns the chat user id of the robot, it's retrieved once.
+func (r apiRenderer) botUserId() string {
+	r.identity.mutex.Lock()
+	defer r.identity.mutex.Unlock()
+	if r.identity.userId != "" {
+		return r.identity.userId
+	}
+	if r.slack {
+		var auth struct {
+			UserId string `json:"user_id"`
+		}
+		err := r.call("POST", "/auth.test", nil, &auth)
+		if err != nil {
+			robot.Logger().Error("Error when retrieving chat identity of the robot: %s", err.Error())
+		}
+		r.identity.userId = auth.UserId
+		return r.identity.userId
+	}
+	var me struct {
+		Id string `json:"id"`
+	}
+	err := r.call("GET", "/api/v4/users/me", nil, &me)
+	if err != nil {
+		robot.Logger().Error("Error when retrieving chat identity of the robot: %s", err.Error())
+	}
+	r.identity.userId = me.Id
+	return r.identity.userId
+}
+func (r apiRenderer) Thread(channelId, threadId string, since time.Time) ([]chatMessage, error) {
+	if r.slack {
+		return r.slackThread(channelId, threadId, since)
+	}
+	if !since.IsZero() {
+		return r.mattermostThreadSince(channelId, threadId, since)
+	}
+	return r.mattermostThread(threadId)
+}
+func (r apiRenderer) slackThread(channelId, threadTs string, since time.Time) ([]chatMessage, error) {
+	var resp struct {
+		Ok       bool   `json:"ok"`
+		Error    string `json:"error"`
+		Messages []struct {
+			User  string `json:"user"`
+			BotId string `json:"bot_id"`
+			Text  string `json:"text"`
+			Ts    string `json:"ts"`
+		} `json:"messages"`
+	}
+	u := fmt.Sprintf(
+		"/conversations.replies?channel=%s&ts=%s",
+		url.QueryEscape(channelId),
+		url.QueryEscape(threadTs),
+	)
+	if !since.IsZero() {
+		u += fmt.Sprintf("&oldest=%d.%06d", since.Unix(), since.Nanosecond() / int(time.Microsecond))
+	}
+	err := r.call("GET", u, nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Ok {
+		return nil, errors.New("slack api error: " + resp.Error)
+	}
+	botUserId := r.botUserId()
+	usernames := make(map[string]string)
+	messages := make([]chatMessage, 0)
+	for _, message := range resp.Messages {
+		ts, _ := strconv.ParseFloat(message.Ts, 64)
+		createdAt := time.Unix(0, int64(ts * float64(time.Second)))
+		// parent message is always sent back
+		if !since.IsZero() && !createdAt.After(since) {
+			continue
+		}
+		if _, ok := usernames[message.User]; !ok {
+			var userResp struct {
+				Ok   bool `json:"ok"`
+				User struct {
+					Name string `json:"name"`
+				} `json:"user"`
+			}
+			r.call("GET", "/users.info?user=" + url.QueryEscape(message.User), nil, &userResp)
+			usernames[message.User] = userResp.User.Name
+		}
+		messages = append(messages, chatMessage{
+			Username: usernames[message.User],
+			Message: message.Text,
+			CreatedAt: createdAt,
+			FromBot: message.User == botUserId || message.BotId != "",
+		})
+	}
+	return messages, nil
+}
+func (r apiRenderer) mattermostThread(postId string) ([]chatMessage, error) {
+	var thread struct {
+		Posts map[string]mattermostThreadPost `json:"posts"`
+	}
+	err := r.call("GET", "/api/v4/posts/" + postId + "/thread", nil, &thread)
+	if err != nil {
+		return nil, err
+	}
+	posts := make([]mattermostThreadPost, 0)
+	for _, post := range thread.Posts {
+		posts = append(posts, post)
+	}
+	return r.mattermostMessages(posts), nil
+}
+
+// mattermostThreadSince reads posts of the channel sent after since and keeps
+// the ones of the thread, it avoids reading the whole thread.
+func (r apiRenderer) mattermostThreadSince(channelId, postId string, since time.Time) ([]chatMessage, error) {
+	var list struct {
+		Posts map[string]mattermostThreadPost `json:"posts"`
+	}
+	err := r.call("GET", fmt.Sprintf(
+		"/api/v4/channels/%s/posts?since=%d",
+		url.PathEscape(channelId),
+		since.UnixNano() / int64(time.Millisecond),
+	), nil, &list)
+	if err != nil {
+		return nil, err
+	}
+	posts := make([]mattermostThreadPost, 0)
+	for id, post := range list.Posts {
+		if id != postId && post.RootId != postId {
+			continue
+		}
+		if post.CreateAt <= since.UnixNano() / int64(time.Millisecond) {
+			continue
+		}
+		posts = append(posts, post)
+	}
+	return r.mattermostMessages(posts), nil
+}
+func (r apiRenderer) mattermostMessages(posts []mattermostThreadPost) []chatMessage {
+	botUserId := r.botUserId()
+	usernames := make(map[string]string)
+	messages := make([]chatMessage, 0)
+	for _, post := range posts {
+		if _, ok := usernames[post.UserId]; !ok {
+			var user struct {
+				Username string `json:"username"`
+			}
+			r.call("GET", "/api/v4/users/" + post.UserId, nil, &user)
+			usernames[post.UserId] = user.Username
+		}
+		messages = append(messages, chatMessage{
+			Username: usernames[post.UserId],
+			Message: post.Message,
+			CreatedAt: time.Unix(0, post.CreateAt * int64(time.Millisecond)),
+			FromBot: post.UserId == botUserId,
+		})
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].CreatedAt.Before(messages[j].CreatedAt)
+	})
+	return messages
+}
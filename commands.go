@@ -25,7 +25,7 @@ func (g GitlabApp) Commands(envelop robot.Envelop) []cli.Command {
 				},
 				{
 					Name:  "last",
-					Usage: "assign the latest notification for merge request or issue to someone or yourself",
+					Usage: "assign the latest notification for merge request or issue to someone, a team member (team:<name>) or yourself",
 					Action: func(c *cli.Context) error {
 						return g.cmdAssignLast(envelop, c)
 					},
@@ -33,14 +33,14 @@ func (g GitlabApp) Commands(envelop robot.Envelop) []cli.Command {
 				{
 					Name: "merge-request",
 					Aliases: []string{"pull-request", "pr", "mr"},
-					Usage: "assign the last merge request received on channel to someone or yourself",
+					Usage: "assign the last merge request received on channel to someone, a team member (team:<name>) or yourself",
 					Action: func(c *cli.Context) error {
 						return g.cmdAssignMergeRequest(envelop, c)
 					},
 				},
 				{
 					Name: "issue",
-					Usage: "assign the last issue received on channel to someone or yourself",
+					Usage: "assign the last issue received on channel to someone, a team member (team:<name>) or yourself",
 					Action: func(c *cli.Context) error {
 						return g.cmdAssignIssue(envelop, c)
 					},
@@ -60,16 +60,78 @@ func (g GitlabApp) Commands(envelop robot.Envelop) []cli.Command {
 				},
 				{
 					Name:  "see",
-					Usage: "See the content of an opened merge request",
+					Usage: "See if a merge request is ready to merge, e.g.: gitlab mr see <id|group/project!12>",
 					Action: g.cmdMergeRequestSee,
 				},
 				{
 					Name: "assign",
-					Usage: "assign a merge request to someone or yourself",
+					Usage: "assign a merge request to someone, a team member (team:<name>) or yourself",
 					Action: func(c *cli.Context) error {
 						return g.cmdMergeRequestAssign(envelop, c)
 					},
 				},
+				{
+					Name: "approve",
+					Usage: "Approve a merge request, e.g.: gitlab mr approve <id|group/project!12>",
+					SkipFlagParsing: true,
+					Action: func(c *cli.Context) error {
+						return g.cmdMergeRequestAction(envelop, c, MR_ACTION_APPROVE)
+					},
+				},
+				{
+					Name: "unapprove",
+					Usage: "Remove your approval on a merge request, e.g.: gitlab mr unapprove <id|group/project!12>",
+					SkipFlagParsing: true,
+					Action: func(c *cli.Context) error {
+						return g.cmdMergeRequestAction(envelop, c, MR_ACTION_UNAPPROVE)
+					},
+				},
+				{
+					Name: "merge",
+					Usage: "Merge a merge request, e.g.: gitlab mr merge <id|group/project!12> [--squash] [--remove-source-branch] [--when-pipeline-succeeds]",
+					SkipFlagParsing: true,
+					Action: func(c *cli.Context) error {
+						return g.cmdMergeRequestAction(envelop, c, MR_ACTION_MERGE)
+					},
+				},
+				{
+					Name: "rebase",
+					Usage: "Rebase a merge request on its target branch, e.g.: gitlab mr rebase <id|group/project!12>",
+					SkipFlagParsing: true,
+					Action: func(c *cli.Context) error {
+						return g.cmdMergeRequestAction(envelop, c, MR_ACTION_REBASE)
+					},
+				},
+				{
+					Name: "close",
+					Usage: "Close a merge request, e.g.: gitlab mr close <id|group/project!12>",
+					SkipFlagParsing: true,
+					Action: func(c *cli.Context) error {
+						return g.cmdMergeRequestAction(envelop, c, MR_ACTION_CLOSE)
+					},
+				},
+				{
+					Name: "reopen",
+					Usage: "Reopen a merge request, e.g.: gitlab mr reopen <id|group/project!12>",
+					SkipFlagParsing: true,
+					Action: func(c *cli.Context) error {
+						return g.cmdMergeRequestAction(envelop, c, MR_ACTION_REOPEN)
+					},
+				},
+				{
+					Name: "bridge",
+					Usage: "Link the merge request discussion in gitlab with a chat thread, e.g.: gitlab mr bridge <id|group/project!12>",
+					Action: func(c *cli.Context) error {
+						return g.cmdMergeRequestBridge(envelop, c)
+					},
+				},
+				{
+					Name: "unbridge",
+					Usage: "Stop linking the merge request discussion with the chat thread of this channel",
+					Action: func(c *cli.Context) error {
+						return g.cmdMergeRequestUnbridge(envelop, c)
+					},
+				},
 			},
 		},
 		{
@@ -83,17 +145,201 @@ func (g GitlabApp) Commands(envelop robot.Envelop) []cli.Command {
 				},
 				{
 					Name:  "see",
-					Usage: "See the content of an opened issue",
+					Usage: "See an issue with its description and last comments, e.g.: gitlab issue see <id|group/project#12>",
 					Action: g.cmdIssueSee,
 
 				},
 				{
 					Name: "assign",
-					Usage: "assign an issue to someone or yourself",
+					Usage: "assign an issue to someone, a team member (team:<name>) or yourself",
 					Action: func(c *cli.Context) error {
 						return g.cmdIssueAssign(envelop, c)
 					},
 				},
+				{
+					Name: "create",
+					Usage: "Create an issue, e.g.: gitlab issue create group/project \"title\" [--label a,b] [--assignee @x] [--milestone m] [--confidential] [--queue]",
+					SkipFlagParsing: true,
+					Action: func(c *cli.Context) error {
+						return g.cmdIssueCreate(envelop, c)
+					},
+				},
+				{
+					Name: "from-here",
+					Usage: "Create an issue from the last messages of this channel or a notification thread, e.g.: gitlab issue from-here group/project \"title\" [--messages 20] [--thread <id|group/project!12>]",
+					SkipFlagParsing: true,
+					Action: func(c *cli.Context) error {
+						return g.cmdIssueFromHere(envelop, c)
+					},
+				},
+			},
+		},
+		{
+			Name:        "pipeline",
+			Usage:       "Control pipelines",
+			Subcommands: []cli.Command{
+				{
+					Name:  "retry",
+					Usage: "Retry failed jobs of a pipeline, e.g.: gitlab pipeline retry group/project [pipeline-id|ref]",
+					Action: func(c *cli.Context) error {
+						return g.cmdPipelineAction(envelop, c, PIPELINE_ACTION_RETRY)
+					},
+				},
+				{
+					Name:  "cancel",
+					Usage: "Cancel running jobs of a pipeline, e.g.: gitlab pipeline cancel group/project [pipeline-id|ref]",
+					Action: func(c *cli.Context) error {
+						return g.cmdPipelineAction(envelop, c, PIPELINE_ACTION_CANCEL)
+					},
+				},
+				{
+					Name:  "run",
+					Usage: "Run a pipeline on a ref, e.g.: gitlab pipeline run group/project master [KEY=VALUE...]",
+					SkipFlagParsing: true,
+					Action: func(c *cli.Context) error {
+						return g.cmdPipelineRun(envelop, c)
+					},
+				},
+				{
+					Name:  "stats",
+					Usage: "Show success rate, durations, queue time, slowest jobs and failure trend of pipelines, e.g.: gitlab pipeline stats group/project [--ref master] [--days 30]",
+					SkipFlagParsing: true,
+					Action: func(c *cli.Context) error {
+						return g.cmdPipelineStats(envelop, c)
+					},
+				},
+			},
+		},
+		{
+			Name:        "job",
+			Usage:       "Control jobs",
+			Subcommands: []cli.Command{
+				{
+					Name:  "retry",
+					Usage: "Retry a job, the project is only needed for jobs not notified by the robot, e.g.: gitlab job retry [group/project] 1234",
+					Action: func(c *cli.Context) error {
+						return g.cmdJobRetry(envelop, c)
+					},
+				},
+				{
+					Name:  "log",
+					Usage: "Show the first error or the last lines of a job log, e.g.: gitlab job log group/project 1234 [--lines 20]",
+					SkipFlagParsing: true,
+					Action: func(c *cli.Context) error {
+						return g.cmdJobLog(envelop, c)
+					},
+				},
+			},
+		},
+		{
+			Name:        "report",
+			Usage:       "Show reports on gitlab activity",
+			Subcommands: []cli.Command{
+				{
+					Name:  "mr",
+					Usage: "Show time to first assignment, time to merge and throughput per project and reviewer, e.g.: gitlab report mr [--team my-team] [--weeks 4]",
+					SkipFlagParsing: true,
+					Action: g.cmdReportMergeRequest,
+				},
+			},
+		},
+		{
+			Name:  "stale",
+			Usage: "List merge requests and issues in queue without activity in gitlab for too long",
+			Action: g.cmdStale,
+		},
+		{
+			Name:  "flaky",
+			Usage: "Rank jobs failing then passing on retry, e.g.: gitlab flaky [group/project]",
+			Action: g.cmdFlaky,
+		},
+		{
+			Name:  "mine",
+			Usage: "Show merge requests, reviews, issues, failing pipelines and to-dos waiting for you in gitlab",
+			Action: func(c *cli.Context) error {
+				return g.cmdMine(envelop, c)
+			},
+		},
+		{
+			Name:  "comment",
+			Usage: "Comment a merge request or an issue, e.g.: gitlab comment <id|group/project!12|group/project#12> \"text\"",
+			SkipFlagParsing: true,
+			Action: func(c *cli.Context) error {
+				return g.cmdComment(envelop, c)
+			},
+		},
+		{
+			Name:  "snooze",
+			Usage: "Stop reminders for a merge request or an issue, e.g.: gitlab snooze <id|group/project!12> <2h|3d|until 2006-01-02> [reason]",
+			Action: g.cmdSnooze,
+		},
+		{
+			Name:  "unsnooze",
+			Usage: "Restore reminders for a snoozed merge request or issue, e.g.: gitlab unsnooze <id|group/project!12>",
+			Action: g.cmdUnsnooze,
+		},
+		{
+			Name:        "template",
+			Usage:       "Manage message templates",
+			Subcommands: []cli.Command{
+				{
+					Name:  "preview",
+					Usage: "Preview the template of an event in this channel, e.g.: gitlab template preview merge-request",
+					Action: func(c *cli.Context) error {
+						return g.cmdTemplatePreview(envelop, c)
+					},
+				},
+			},
+		},
+		{
+			Name:        "team",
+			Usage:       "Manage teams used for mentions, assignment and notification channels",
+			Subcommands: []cli.Command{
+				{
+					Name:  "list",
+					Usage: "List all teams with their members and projects",
+					Action: g.cmdTeamList,
+				},
+				{
+					Name:  "show",
+					Usage: "Show a team",
+					Action: g.cmdTeamShow,
+				},
+				{
+					Name:  "create",
+					Usage: "Create a new team",
+					Action: g.cmdTeamCreate,
+				},
+				{
+					Name:  "delete",
+					Usage: "Delete a team",
+					Action: g.cmdTeamDelete,
+				},
+				{
+					Name:  "add-member",
+					Usage: "Add members to a team, e.g.: gitlab team add-member backend @john @jane",
+					Action: g.cmdTeamAddMember,
+				},
+				{
+					Name:  "remove-member",
+					Usage: "Remove members from a team",
+					Action: g.cmdTeamRemoveMember,
+				},
+				{
+					Name:  "add-path",
+					Usage: "Make a team owner of projects or groups, e.g.: gitlab team add-path backend mygroup/api",
+					Action: g.cmdTeamAddPath,
+				},
+				{
+					Name:  "remove-path",
+					Usage: "Remove projects or groups owned by a team",
+					Action: g.cmdTeamRemovePath,
+				},
+				{
+					Name:  "set-channel",
+					Usage: "Notify projects owned by a team in a channel, without channel the default one is used, e.g.: gitlab team set-channel backend #backend",
+					Action: g.cmdTeamSetChannel,
+				},
 			},
 		},
 	}
@@ -149,19 +395,11 @@ func (g GitlabApp) cmdIssueList(c *cli.Context) error {
 	}, true))
 	return nil
 }
-func (g GitlabApp) cmdIssueSee(c *cli.Context) error {
-	g.cmdSee(c, ISSUE_EVENT_NAME)
-	return nil
-}
 
 func (g GitlabApp) cmdIssueAssign(envelop robot.Envelop, c *cli.Context) error {
 	g.cmdMergeOrIssueAssign(envelop, c, ISSUE_EVENT_NAME)
 	return nil
 }
-func (g GitlabApp) cmdMergeRequestSee(c *cli.Context) error {
-	g.cmdSee(c, MERGE_REQUEST_EVENT_NAME)
-	return nil
-}
 func (g GitlabApp) cmdMergeRequestAssign(envelop robot.Envelop, c *cli.Context) error {
 	g.cmdMergeOrIssueAssign(envelop, c, MERGE_REQUEST_EVENT_NAME)
 	return nil
@@ -189,36 +427,20 @@ func (g GitlabApp) cmdMergeOrIssueAssign(envelop robot.Envelop, c *cli.Context,
 	g.cmdAssign(username, c, where)
 
 }
-func (g GitlabApp) cmdSee(c *cli.Context, notifType string) {
-	notifStr := strings.Replace(notifType, "_", " ", -1)
-	notifIdStr := c.Args().First()
-	if notifIdStr == "" {
-		fmt.Fprintln(c.App.Writer, "I need the id retrieve from list to be able to show you the content of the " + notifStr + ".")
-		return
-	}
-	notifId, err := strconv.Atoi(notifIdStr)
-	if err != nil {
-		fmt.Fprintln(c.App.Writer, "You gave me an incorrect id.")
-		return
-	}
-	where := &GitlabNotification{
-		Type: notifType,
-	}
-	where.ID = uint(notifId)
-	var notif GitlabNotification
-	robot.Store().Where(&where).First(&notif)
-	if notif.ID == 0 {
-		fmt.Fprintln(c.App.Writer, "I can't found this " + notifStr + ".")
-		return
-	}
-	fmt.Fprint(c.App.Writer, notif.Message)
-}
 func (g GitlabApp) usernameFromCommand(envelop robot.Envelop, c *cli.Context) string {
 	username := c.Args().First()
 	if username == "" {
-		fmt.Fprint(c.App.Writer, "I can't assign someone to the last merge request, no user name was given (it can be 'me' or other user name).")
+		fmt.Fprint(c.App.Writer, "I can't assign someone to the last merge request, no user name was given (it can be 'me', 'team:<name>' or other user name).")
 		return ""
 	}
+	if strings.HasPrefix(username, TEAM_PREFIX) {
+		member, err := g.pickTeamMember(strings.TrimPrefix(username, TEAM_PREFIX))
+		if err != nil {
+			fmt.Fprint(c.App.Writer, err.Error())
+			return ""
+		}
+		return member
+	}
 	if username == "me" {
 		username = envelop.User.Name
 	} else {
@@ -259,22 +481,20 @@ func (g GitlabApp) cmdAssign(username string, c *cli.Context, where *GitlabNotif
 	)
 	notif.AssignedUser = username
 	robot.Store().Save(&notif)
+	if notif.Type == MERGE_REQUEST_EVENT_NAME {
+		recordMergeRequestAssignment(notif.ProjectID, notif.ProjectPath, notif.ObjectIid, g.retrieveGitlabUserFromChat(username))
+	}
+	g.followUp(notif, "Assigned to @" + username + " from chat.")
+	g.noteClaim(notif, username)
 }
 func (g GitlabApp) assignIssue(notif GitlabNotification, issueId int, user string) error {
 	fUser, err := g.findUser(user)
 	if err != nil {
 		return err
 	}
-	var accessLevel gitlab.AccessLevelValue
-	member, _, err := g.client.Projects.GetProjectMember(notif.GroupName, fUser.ID)
-	if err != nil || member.ID == 0 {
-		userGroup, _, err := g.GetGroupMember(notif.GroupName, fUser.ID, nil)
-		if err != nil {
-			return err
-		}
-		accessLevel = userGroup.AccessLevel
-	} else {
-		accessLevel = member.AccessLevel
+	accessLevel, err := g.userAccessLevel(notif, fUser.Username)
+	if err != nil {
+		return err
 	}
 	if accessLevel < gitlab.MasterPermissions {
 		return errors.New("Nice try but you don't have the correct permission.")
@@ -292,16 +512,9 @@ func (g GitlabApp) assignMergeRequest(notif GitlabNotification, mrId int, user s
 	if err != nil {
 		return err
 	}
-	var accessLevel gitlab.AccessLevelValue
-	member, _, err := g.client.Projects.GetProjectMember(notif.GroupName, fUser.ID)
-	if err != nil || member.ID == 0 {
-		userGroup, _, err := g.GetGroupMember(notif.GroupName, fUser.ID, nil)
-		if err != nil {
-			return err
-		}
-		accessLevel = userGroup.AccessLevel
-	} else {
-		accessLevel = member.AccessLevel
+	accessLevel, err := g.userAccessLevel(notif, fUser.Username)
+	if err != nil {
+		return err
 	}
 	if accessLevel < gitlab.MasterPermissions {
 		return errors.New("Nice try but you don't have the correct permission.")
@@ -0,0 +1,271 @@
+package gubot_gitlab
+
+import (
+	"fmt"
+	"github.com/ArthurHlt/gubot/robot"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const DEFAULT_CRON = "*/10 * * * *"
+
+// cronSchedule is a parsed cron expression in format:
+// minute hour day-of-month month day-of-week
+// each field accepts *, numbers, ranges (1-5), lists (1,3) and steps (*/10,
+// 1-30/5 or 5/10 which runs from 5 to the maximum)
+type cronSchedule struct {
+	minutes  map[int]bool
+	hours    map[int]bool
+	days     map[int]bool
+	months   map[int]bool
+	weekdays map[int]bool
+	// restricted day of month and day of week are matched with a logical or
+	daysRestricted     bool
+	weekdaysRestricted bool
+	location           *time.Location
+}
+type cronJob struct {
+	name       string
+	schedule   *cronSchedule
+	runOnStart bool
+	run        func()
+}
+
+func parseCron(expr string, location *time.Location) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("Invalid cron expression '%s', it must have 5 fields.", expr)
+	}
+	if location == nil {
+		location = time.Local
+	}
+	bounds := [][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	values := make([]map[int]bool, 5)
+	for i, field := range fields {
+		fieldValues, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid cron expression '%s': %s", expr, err.Error())
+		}
+		values[i] = fieldValues
+	}
+	// 7 is also sunday
+	if values[4][7] {
+		values[4][0] = true
+	}
+	return &cronSchedule{
+		minutes: values[0],
+		hours: values[1],
+		days: values[2],
+		months: values[3],
+		weekdays: values[4],
+		daysRestricted: fields[2] != "*",
+		weekdaysRestricted: fields[4] != "*",
+		location: location,
+	}, nil
+}
+func parseCronInTimeZone(expr string, timeZone string) (*cronSchedule, error) {
+	if timeZone == "" {
+		return parseCron(expr, nil)
+	}
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return nil, err
+	}
+	return parseCron(expr, location)
+}
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		hasStep := false
+		if index := strings.Index(part, "/"); index >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[index + 1:])
+			if err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step in '%s'", part)
+			}
+			part = part[:index]
+			hasStep = true
+		}
+		start, end := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			start, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid value '%s'", part)
+			}
+			end = start
+			// a step after a single value runs from the value to max (e.g.: 5/10)
+			if hasStep {
+				end = max
+			}
+			if len(bounds) == 2 {
+				end, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid range '%s'", part)
+				}
+			}
+		}
+		if start < min || end > max || start > end {
+			return nil, fmt.Errorf("value '%s' out of range %d-%d", part, min, max)
+		}
+		for value := start; value <= end; value += step {
+			values[value] = true
+		}
+	}
+	return values, nil
+}
+func (c cronSchedule) match(t time.Time) bool {
+	t = t.In(c.location)
+	if !c.minutes[t.Minute()] || !c.hours[t.Hour()] || !c.months[int(t.Month())] {
+		return false
+	}
+	dayMatch := c.days[t.Day()]
+	weekdayMatch := c.weekdays[int(t.Weekday())]
+	if c.daysRestricted && c.weekdaysRestricted {
+		return dayMatch || weekdayMatch
+	}
+	return dayMatch && weekdayMatch
+}
+func (g *GitlabApp) loadCronJobs() error {
+	if g.conf.GitlabHooksCron == "" {
+		g.conf.GitlabHooksCron = DEFAULT_CRON
+	}
+	if g.conf.GitlabRemindersCron == "" {
+		g.conf.GitlabRemindersCron = DEFAULT_CRON
+	}
+	hooksSchedule, err := parseCron(g.conf.GitlabHooksCron, nil)
+	if err != nil {
+		return err
+	}
+	remindersSchedule, err := parseCron(g.conf.GitlabRemindersCron, nil)
+	if err != nil {
+		return err
+	}
+	bridgesSchedule, err := parseCron(BRIDGE_CRON, nil)
+	if err != nil {
+		return err
+	}
+	jobsSchedule, err := parseCron(JOB_HISTORY_CRON, nil)
+	if err != nil {
+		return err
+	}
+	g.cronJobs = []cronJob{
+		{
+			name: "hooks",
+			schedule: hooksSchedule,
+			runOnStart: true,
+			run: g.hooksJob,
+		},
+		{
+			name: "reminders",
+			schedule: remindersSchedule,
+			runOnStart: true,
+			run: g.remindersJob,
+		},
+		{
+			name: "bridges",
+			schedule: bridgesSchedule,
+			run: g.syncBridges,
+		},
+		{
+			name: "jobs history",
+			schedule: jobsSchedule,
+			run: g.pruneJobs,
+		},
+	}
+	for _, digest := range g.conf.GitlabDigests {
+		digestSchedule, err := parseCronInTimeZone(digest.Cron, digest.TimeZone)
+		if err != nil {
+			return err
+		}
+		channel := digest.Channel
+		if channel == "" {
+			channel = g.conf.GitlabNotifyChannel
+		}
+		g.cronJobs = append(g.cronJobs, cronJob{
+			name: "digest " + channel,
+			schedule: digestSchedule,
+			run: func() {
+				g.sendDigest(channel)
+			},
+		})
+	}
+	for _, digest := range g.conf.GitlabPersonalDigests {
+		digestSchedule, err := parseCronInTimeZone(digest.Cron, digest.TimeZone)
+		if err != nil {
+			return err
+		}
+		username := strings.TrimPrefix(digest.User, "@")
+		g.cronJobs = append(g.cronJobs, cronJob{
+			name: "personal digest " + username,
+			schedule: digestSchedule,
+			run: func() {
+				g.sendPersonalDigest(username)
+			},
+		})
+	}
+	for _, report := range g.conf.GitlabMergeRequestReports {
+		reportSchedule, err := parseCronInTimeZone(report.Cron, report.TimeZone)
+		if err != nil {
+			return err
+		}
+		channel := report.Channel
+		if channel == "" {
+			channel = g.conf.GitlabNotifyChannel
+		}
+		team := report.Team
+		weeks := report.Weeks
+		if weeks <= 0 {
+			weeks = 1
+		}
+		g.cronJobs = append(g.cronJobs, cronJob{
+			name: "merge request report " + channel,
+			schedule: reportSchedule,
+			run: func() {
+				g.sendMergeRequestReport(channel, team, weeks)
+			},
+		})
+	}
+	return nil
+}
+
+// startCron runs every cron job when its schedule match, it checks schedules
+// at the beginning of each minute. A job still running when its schedule
+// match again is skipped.
+func (g GitlabApp) startCron() {
+	running := make([]int32, len(g.cronJobs))
+	start := func(i int) {
+		job := g.cronJobs[i]
+		if !atomic.CompareAndSwapInt32(&running[i], 0, 1) {
+			robot.Logger().Debug("Cron job %s is still running, skipped", job.name)
+			return
+		}
+		go func() {
+			defer atomic.StoreInt32(&running[i], 0)
+			job.run()
+		}()
+	}
+	for i, job := range g.cronJobs {
+		if job.runOnStart {
+			start(i)
+		}
+	}
+	go func() {
+		for {
+			now := time.Now()
+			time.Sleep(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+			tick := time.Now().Truncate(time.Minute)
+			for i, job := range g.cronJobs {
+				if !job.schedule.match(tick) {
+					continue
+				}
+				robot.Logger().Debug("Running cron job %s", job.name)
+				start(i)
+			}
+		}
+	}()
+}
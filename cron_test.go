@@ -0,0 +1,52 @@
+package gubot_gitlab
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		field   string
+		min     int
+		max     int
+		values  []int
+		invalid bool
+	}{
+		{field: "*", min: 0, max: 6, values: []int{0, 1, 2, 3, 4, 5, 6}},
+		{field: "5", min: 0, max: 59, values: []int{5}},
+		{field: "1-5", min: 0, max: 6, values: []int{1, 2, 3, 4, 5}},
+		{field: "1,3,5", min: 0, max: 6, values: []int{1, 3, 5}},
+		{field: "*/15", min: 0, max: 59, values: []int{0, 15, 30, 45}},
+		{field: "1-30/10", min: 0, max: 59, values: []int{1, 11, 21}},
+		{field: "5/10", min: 0, max: 59, values: []int{5, 15, 25, 35, 45, 55}},
+		{field: "0,30/15", min: 0, max: 59, values: []int{0, 30, 45}},
+		{field: "60", min: 0, max: 59, invalid: true},
+		{field: "5-1", min: 0, max: 59, invalid: true},
+		{field: "*/0", min: 0, max: 59, invalid: true},
+		{field: "a", min: 0, max: 59, invalid: true},
+		{field: "1-b", min: 0, max: 59, invalid: true},
+	}
+	for _, test := range tests {
+		values, err := parseCronField(test.field, test.min, test.max)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("parseCronField(%q) should fail", test.field)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseCronField(%q) failed: %s", test.field, err.Error())
+			continue
+		}
+		got := make([]int, 0)
+		for value := range values {
+			got = append(got, value)
+		}
+		sort.Ints(got)
+		if !reflect.DeepEqual(got, test.values) {
+			t.Errorf("parseCronField(%q) = %v, want %v", test.field, got, test.values)
+		}
+	}
+}
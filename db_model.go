@@ -1,12 +1,18 @@
 package gubot_gitlab
 
-import "github.com/jinzhu/gorm"
+import (
+	"github.com/jinzhu/gorm"
+	"time"
+)
 
 type GitlabHook struct {
 	gorm.Model
 	ProjectID   int
 	ProjectName string
 	Skipped     bool
+	// PushEvents is set when the hook sends pushes, hooks created before
+	// were edited to send them
+	PushEvents  bool
 }
 
 type GitlabNotification struct {
@@ -14,11 +20,131 @@ type GitlabNotification struct {
 	ProjectID    int
 	ProjectName  string
 	GroupName    string
+	ProjectPath  string
 	Type         string
 	ObjectId     int
+	ObjectIid    int
 	Message      string
 	ChannelName  string
 	WebUrl       string
 	ProjectUrl   string
 	AssignedUser string
+	ReminderCount   int
+	LastRemindedAt  *time.Time
+	EscalationLevel int
+	LastEscalatedAt *time.Time
+	SnoozedUntil    *time.Time
+	SnoozeReason    string
+	// LastActivityAt is the time of the last event received from gitlab on
+	// the merge request or the issue
+	LastActivityAt  *time.Time
+	StaleNotifiedAt *time.Time
+	SourceBranch    string
+	ChatChannelId   string
+	ChatMessageId   string
+	CardData        string `sql:"type:text"`
+	// Card is the rich message sent along with the first notification only
+	Card            *ChatCard `gorm:"-"`
+}
+
+func (n GitlabNotification) IsSnoozed() bool {
+	return n.SnoozedUntil != nil && n.SnoozedUntil.After(time.Now())
+}
+
+type GitlabTeam struct {
+	gorm.Model
+	Name    string
+	Members string
+	Paths   string
+	// Channel receives notifications of projects owned by the team
+	Channel string
+}
+
+type GitlabDeferredMessage struct {
+	gorm.Model
+	NotificationID uint
+	ChannelName    string
+	Username       string
+	Message        string
+	SendAt         time.Time
+	// Reminder is set for reminders, they are not sent once the notification is claimed
+	Reminder       bool
+}
+
+type GitlabPipeline struct {
+	gorm.Model
+	PipelineID    int
+	ProjectPath   string
+	ProjectUrl    string
+	Ref           string
+	Sha           string
+	Status        string
+	Username      string
+	DefaultBranch bool
+	Duration      int
+	// QueuedDuration is the time in seconds the pipeline waited for a runner
+	QueuedDuration int
+	WebUrl        string
+}
+
+type GitlabChatMessage struct {
+	gorm.Model
+	ChannelName string
+	Username    string
+	Message     string `sql:"type:text"`
+}
+
+// GitlabBridge links a chat thread to a merge request discussion,
+// LastSyncedAt is the time in milliseconds of the last chat message relayed.
+type GitlabBridge struct {
+	gorm.Model
+	ProjectID     int
+	ProjectPath   string
+	ObjectIid     int
+	WebUrl        string
+	ChannelName   string
+	ChatChannelId string
+	ChatThreadId  string
+	LastSyncedAt  int64
+}
+
+// GitlabJob is an outcome of a job, Retried is set when an earlier job with
+// the same name ran on the same sha and Flaky when the job failed and then
+// passed on retry.
+type GitlabJob struct {
+	gorm.Model
+	JobID        int
+	PipelineID   int
+	ProjectID    int
+	ProjectPath  string
+	Name         string
+	Stage        string
+	Ref          string
+	Sha          string
+	Status       string
+	AllowFailure bool
+	Duration     float64
+	Retried      bool
+	Flaky        bool
+}
+
+// GitlabMergeStatus is the last merge status known for an opened merge
+// request (can_be_merged or cannot_be_merged).
+type GitlabMergeStatus struct {
+	gorm.Model
+	ProjectID int
+	ObjectIid int
+	Status    string
+}
+
+// GitlabMergeRequestTransition is a step in the life of a merge request
+// (opened, assigned, merged...), Username is the assignee for an assignment
+// and the author of the action otherwise.
+type GitlabMergeRequestTransition struct {
+	gorm.Model
+	ProjectID   int
+	ProjectPath string
+	ObjectIid   int
+	Action      string
+	Username    string
 }
@@ -0,0 +1,99 @@
+package gubot_gitlab
+
+import (
+	"fmt"
+	"github.com/ArthurHlt/gubot/robot"
+	"time"
+)
+
+func (g GitlabApp) sendDigest(channelName string) {
+	var notifs []GitlabNotification
+	robot.Store().Where(&GitlabNotification{
+		ChannelName: channelName,
+	}).Order("project_id asc").Find(&notifs)
+
+	now := time.Now()
+	unassigned := make([]GitlabNotification, 0)
+	assigned := make([]GitlabNotification, 0)
+	stale := make([]GitlabNotification, 0)
+	for _, notif := range notifs {
+		if notif.IsSnoozed() {
+			continue
+		}
+		if notif.Type != MERGE_REQUEST_EVENT_NAME && notif.Type != ISSUE_EVENT_NAME {
+			continue
+		}
+		if notif.AssignedUser == "" {
+			unassigned = append(unassigned, notif)
+		} else {
+			assigned = append(assigned, notif)
+		}
+		if g.isStale(notif, now) && !g.isStale(notif, now.Add(-24 * time.Hour)) {
+			stale = append(stale, notif)
+		}
+	}
+
+	message := "**Daily digest**\n"
+	if len(unassigned) == 0 && len(assigned) == 0 {
+		message += "There is no issues or merge requests in queue.\n"
+	}
+	if len(unassigned) > 0 {
+		message += "\n**Waiting for someone:**\n" + g.formatNotifs(unassigned, true)
+	}
+	if len(assigned) > 0 {
+		message += "\n**In progress:**\n" + g.formatNotifs(assigned, true)
+	}
+	failingPipelines := g.failingDefaultPipelines()
+	if len(failingPipelines) > 0 {
+		message += "\n**Failing pipelines on default branch:**\n"
+		for _, pipeline := range failingPipelines {
+			message += fmt.Sprintf(
+				"- [%s](%s) on %s: [#%d](%s) (%s)\n",
+				pipeline.ProjectPath,
+				pipeline.ProjectUrl,
+				pipeline.Ref,
+				pipeline.PipelineID,
+				pipeline.WebUrl,
+				formatAge(now.Sub(pipeline.UpdatedAt)),
+			)
+		}
+	}
+	if len(stale) > 0 {
+		message += "\n**Went stale since yesterday:**\n" + g.formatNotifs(stale, false)
+	}
+	robot.SendMessages(robot.Envelop{
+		ChannelName: channelName,
+	}, message)
+}
+
+// isStale returns true if the notification had no activity in gitlab for
+// more than the days of its stale rule at the given time.
+func (g GitlabApp) isStale(notif GitlabNotification, at time.Time) bool {
+	days := g.staleDays(notif)
+	if days <= 0 {
+		return false
+	}
+	staleTime := notif.lastActivity().Add(time.Duration(days) * 24 * time.Hour)
+	return !staleTime.After(at)
+}
+
+// failingDefaultPipelines returns the last pipeline on default branch of each
+// project when it has failed.
+func (g GitlabApp) failingDefaultPipelines() []GitlabPipeline {
+	var pipelines []GitlabPipeline
+	robot.Store().Where(&GitlabPipeline{
+		DefaultBranch: true,
+	}).Order("pipeline_id desc").Find(&pipelines)
+	seenProjects := make(map[string]bool)
+	failingPipelines := make([]GitlabPipeline, 0)
+	for _, pipeline := range pipelines {
+		if seenProjects[pipeline.ProjectPath] || g.isFilteredRepo(pipeline.ProjectPath) {
+			continue
+		}
+		seenProjects[pipeline.ProjectPath] = true
+		if pipeline.Status == "failed" {
+			failingPipelines = append(failingPipelines, pipeline)
+		}
+	}
+	return failingPipelines
+}
@@ -0,0 +1,148 @@
+package gubot_gitlab
+
+import (
+	"github.com/ArthurHlt/gubot/robot"
+	"github.com/xanzy/go-gitlab"
+	"strings"
+	"time"
+)
+
+const (
+	ESCALATION_ACTION_CHANNEL = "channel"
+	ESCALATION_ACTION_MAINTAINERS = "maintainers"
+	ESCALATION_ACTION_OWNERS = "owners"
+)
+
+func (s GitlabEscalationStep) match(notif GitlabNotification) bool {
+	if s.AfterReminders > 0 && notif.ReminderCount < s.AfterReminders {
+		return false
+	}
+	waitingTime := time.Duration(s.AfterHours) * time.Hour
+	if s.AfterHours > 0 && notif.CreatedAt.Add(waitingTime).After(time.Now()) {
+		return false
+	}
+	return true
+}
+func (g GitlabApp) isReminderDue(notif GitlabNotification) bool {
+	lastReminder := notif.CreatedAt
+	if notif.LastRemindedAt != nil {
+		lastReminder = *notif.LastRemindedAt
+	}
+	notifTime := lastReminder.Add(time.Duration(g.conf.GitlabNotifyInMinute) * time.Minute)
+	return !notifTime.After(time.Now())
+}
+
+// escalationLevel returns the level of the last escalation step matching the
+// notification, level 0 means there is no escalation.
+func (g GitlabApp) escalationLevel(notif GitlabNotification) int {
+	level := 0
+	for i, step := range g.conf.GitlabEscalationSteps {
+		if step.match(notif) {
+			level = i + 1
+		}
+	}
+	return level
+}
+func (g GitlabApp) remind(notif *GitlabNotification) {
+	// reminder sent in the channel of an escalation step waits for the working
+	// time of this channel
+	if channelName := g.escalationChannel(*notif); channelName != "" && !g.isWorkingTime(channelName, "") {
+		return
+	}
+	now := time.Now()
+	notif.ReminderCount++
+	notif.LastRemindedAt = &now
+	level := g.escalationLevel(*notif)
+	if level > notif.EscalationLevel {
+		notif.EscalationLevel = level
+		notif.LastEscalatedAt = &now
+	}
+	robot.Store().Save(notif)
+
+	reminder := *notif
+	reminder.Message = "Guys, don't forget -- " + notif.Message
+	if level == 0 {
+		g.notify(&reminder)
+		return
+	}
+	step := g.conf.GitlabEscalationSteps[level - 1]
+	if step.Channel != "" {
+		reminder.ChannelName = step.Channel
+	}
+	switch step.Action {
+	case ESCALATION_ACTION_MAINTAINERS:
+		g.remindMaintainers(reminder)
+		break
+	case ESCALATION_ACTION_OWNERS:
+		g.remindOwners(reminder)
+		break
+	default:
+		g.notify(&reminder)
+	}
+}
+
+// escalationChannel returns the channel where the next reminder is sent when
+// an escalation step sends it in another channel than the notification one.
+func (g GitlabApp) escalationChannel(notif GitlabNotification) string {
+	notif.ReminderCount++
+	level := g.escalationLevel(notif)
+	if level == 0 {
+		return ""
+	}
+	step := g.conf.GitlabEscalationSteps[level - 1]
+	if step.Action == ESCALATION_ACTION_MAINTAINERS {
+		return ""
+	}
+	return step.Channel
+}
+// remindMaintainers sends the reminder to each maintainer, following the
+// mention policy it's only sent in the channel when mentions are disabled and
+// with the overflow mention when there are too many maintainers.
+func (g GitlabApp) remindMaintainers(notif GitlabNotification) {
+	policy := g.mentionPolicy(notif)
+	if policy.NoMentions {
+		g.sendEscalation(notif, "")
+		return
+	}
+	users, err := g.usersToMention(notif, policy)
+	if err != nil {
+		robot.Logger().Error("Error when escalating to maintainers: %s", err.Error())
+		return
+	}
+	if policy.MaxMentions > 0 && len(users) > policy.MaxMentions {
+		g.sendEscalation(notif, g.overflowMention(notif, policy))
+		return
+	}
+	for _, user := range users {
+		g.sendReminderOrDefer(user, notif.Message, notif.ID)
+	}
+}
+func (g GitlabApp) remindOwners(notif GitlabNotification) {
+	policy := g.mentionPolicy(notif)
+	if policy.NoMentions {
+		g.sendEscalation(notif, "")
+		return
+	}
+	owners, err := g.userWithPermissionFromProjects(notif, gitlab.OwnerPermission)
+	if err != nil {
+		robot.Logger().Error("Error when escalating to owners: %s", err.Error())
+		return
+	}
+	mentions := ""
+	if len(owners) > 0 {
+		mentions = "@" + strings.Join(owners, " @")
+	}
+	if policy.MaxMentions > 0 && len(owners) > policy.MaxMentions {
+		mentions = g.overflowMention(notif, policy)
+	}
+	g.sendEscalation(notif, mentions)
+}
+func (g GitlabApp) sendEscalation(notif GitlabNotification, mentions string) {
+	message := notif.Message
+	if mentions != "" {
+		message = mentions + " : " + message
+	}
+	robot.SendMessages(robot.Envelop{
+		ChannelName: notif.ChannelName,
+	}, message)
+}
@@ -0,0 +1,181 @@
+package gubot_gitlab
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ArthurHlt/gubot/robot"
+	"github.com/urfave/cli"
+	"github.com/xanzy/go-gitlab"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	FLAKY_DAYS = 30
+	FLAKY_REPORT_SIZE = 10
+	// JOB_HISTORY_DAYS is how long jobs are kept, pipeline stats read them too
+	JOB_HISTORY_DAYS = 90
+	JOB_HISTORY_CRON = "0 4 * * *"
+)
+
+type flakyJob struct {
+	ProjectPath string
+	Name        string
+	Flaky       int
+	Runs        int
+}
+
+// recordJob keeps the outcome of a job, when a job passes after a failure on
+// the same sha the failed jobs are flagged as flaky.
+func (g GitlabApp) recordJob(webhook []byte) {
+	var buildEvent gitlab.BuildEvent
+	buildEvent.Repository = &gitlab.Repository{}
+	json.Unmarshal(webhook, &buildEvent)
+	projectPath := buildProjectPath(webhook, buildEvent)
+	if g.isFilteredRepo(projectPath) {
+		return
+	}
+	var job GitlabJob
+	robot.Store().Where(map[string]interface{}{
+		"job_id": buildEvent.BuildID,
+		"project_id": buildEvent.ProjectID,
+	}).First(&job)
+	job.JobID = buildEvent.BuildID
+	job.PipelineID = buildEvent.Commit.ID
+	job.ProjectID = buildEvent.ProjectID
+	job.ProjectPath = projectPath
+	job.Name = buildEvent.BuildName
+	job.Stage = buildEvent.BuildStage
+	job.Ref = buildEvent.Ref
+	job.Sha = buildEvent.SHA
+	job.Status = buildEvent.BuildStatus
+	job.AllowFailure = buildEvent.BuildAllowFailure
+	job.Duration = buildEvent.BuildDuration
+
+	sameJob := map[string]interface{}{
+		"project_id": job.ProjectID,
+		"name": job.Name,
+		"sha": job.Sha,
+	}
+	var previousCount int
+	robot.Store().Model(&GitlabJob{}).Where(sameJob).Where("job_id < ?", job.JobID).Count(&previousCount)
+	job.Retried = previousCount > 0
+	robot.Store().Save(&job)
+
+	if job.Status != "success" || !job.Retried {
+		return
+	}
+	robot.Store().Model(&GitlabJob{}).Where(sameJob).Where("status = ? AND job_id < ?", "failed", job.JobID).Update("flaky", true)
+}
+
+// buildProjectPath returns the path of the project of a job webhook, job
+// webhooks don't send repository path so it's taken from project when sent or
+// from the repository homepage.
+func buildProjectPath(webhook []byte, buildEvent gitlab.BuildEvent) string {
+	var projectEvent struct {
+		Project struct {
+			PathWithNamespace string `json:"path_with_namespace"`
+		} `json:"project"`
+	}
+	json.Unmarshal(webhook, &projectEvent)
+	if projectEvent.Project.PathWithNamespace != "" {
+		return projectEvent.Project.PathWithNamespace
+	}
+	if buildEvent.Repository == nil {
+		return ""
+	}
+	homepage, err := url.Parse(buildEvent.Repository.Homepage)
+	if err != nil {
+		return ""
+	}
+	return strings.Trim(homepage.Path, "/")
+}
+
+// flakyCount returns the number of flaky failures of a job during last days.
+func (g GitlabApp) flakyCount(projectID int, jobName string) int {
+	var count int
+	robot.Store().Model(&GitlabJob{}).Where(map[string]interface{}{
+		"project_id": projectID,
+		"name": jobName,
+		"flaky": true,
+	}).Where("created_at > ?", time.Now().AddDate(0, 0, -FLAKY_DAYS)).Count(&count)
+	return count
+}
+
+// pruneJobs removes jobs older than JOB_HISTORY_DAYS.
+func (g GitlabApp) pruneJobs() {
+	robot.Store().Unscoped().Where("created_at < ?", time.Now().AddDate(0, 0, -JOB_HISTORY_DAYS)).Delete(GitlabJob{})
+}
+func (g GitlabApp) cmdFlaky(c *cli.Context) error {
+	projectID := 0
+	if projectPath := c.Args().First(); projectPath != "" {
+		project, _, err := g.client.Projects.GetProject(projectPath)
+		if err != nil {
+			fmt.Fprintf(c.App.Writer, "Sorry I can't found project %s: %s", projectPath, err.Error())
+			return nil
+		}
+		projectID = project.ID
+	}
+	jobs := flakyJobs(projectID)
+	if len(jobs) == 0 {
+		fmt.Fprintf(c.App.Writer, "No flaky jobs during the last %d days.", FLAKY_DAYS)
+		return nil
+	}
+	message := fmt.Sprintf("**Flakiest jobs during the last %d days:**\n", FLAKY_DAYS)
+	for i, job := range jobs {
+		message += fmt.Sprintf(
+			"%d. %s: **%s**, %d flaky failure(s) on %d run(s) (%d%%)\n",
+			i + 1,
+			job.ProjectPath,
+			job.Name,
+			job.Flaky,
+			job.Runs,
+			job.Flaky * 100 / job.Runs,
+		)
+	}
+	fmt.Fprint(c.App.Writer, message)
+	return nil
+}
+
+// flakyJobs ranks jobs by their number of flaky failures during last days,
+// a project id 0 ranks jobs of every projects.
+func flakyJobs(projectID int) []*flakyJob {
+	db := robot.Store().Where("created_at > ?", time.Now().AddDate(0, 0, -FLAKY_DAYS))
+	if projectID != 0 {
+		db = db.Where("project_id = ?", projectID)
+	}
+	var jobs []GitlabJob
+	db.Find(&jobs)
+	jobsByName := make(map[string]*flakyJob)
+	for _, job := range jobs {
+		key := fmt.Sprintf("%d/%s", job.ProjectID, job.Name)
+		if _, ok := jobsByName[key]; !ok {
+			jobsByName[key] = &flakyJob{
+				ProjectPath: job.ProjectPath,
+				Name: job.Name,
+			}
+		}
+		jobsByName[key].Runs++
+		if job.Flaky {
+			jobsByName[key].Flaky++
+		}
+	}
+	flakyJobs := make([]*flakyJob, 0)
+	for _, job := range jobsByName {
+		if job.Flaky > 0 {
+			flakyJobs = append(flakyJobs, job)
+		}
+	}
+	sort.Slice(flakyJobs, func(i, j int) bool {
+		if flakyJobs[i].Flaky == flakyJobs[j].Flaky {
+			return flakyJobs[i].Flaky * flakyJobs[j].Runs > flakyJobs[j].Flaky * flakyJobs[i].Runs
+		}
+		return flakyJobs[i].Flaky > flakyJobs[j].Flaky
+	})
+	if len(flakyJobs) > FLAKY_REPORT_SIZE {
+		flakyJobs = flakyJobs[:FLAKY_REPORT_SIZE]
+	}
+	return flakyJobs
+}
@@ -1,9 +1,15 @@
 package gubot_gitlab
 
 import (
+	"github.com/ArthurHlt/gubot/robot"
 	"github.com/xanzy/go-gitlab"
 	"fmt"
 	"strconv"
+	"strings"
+	"path"
+	"net/http"
+	"net/url"
+	"time"
 )
 
 func (g GitlabApp) GetGroupMember(gid interface{}, user int, opt *gitlab.UpdateGroupMemberOptions, options ...gitlab.OptionFunc) (*gitlab.GroupMember, *gitlab.Response, error) {
@@ -11,7 +17,7 @@ func (g GitlabApp) GetGroupMember(gid interface{}, user int, opt *gitlab.UpdateG
 	if err != nil {
 		return nil, nil, err
 	}
-	u := fmt.Sprintf("groups/%s/members/%d", group, user)
+	u := fmt.Sprintf("groups/%s/members/all/%d", url.QueryEscape(group), user)
 
 	req, err := g.client.NewRequest("GET", u, opt, options)
 	if err != nil {
@@ -26,6 +32,29 @@ func (g GitlabApp) GetGroupMember(gid interface{}, user int, opt *gitlab.UpdateG
 
 	return grp, resp, err
 }
+
+// listAllPages requests every pages of a gitlab list endpoint, fetch is
+// called on each page and must decode the response.
+func (g GitlabApp) listAllPages(u string, fetch func(req *http.Request) (*gitlab.Response, error)) error {
+	opt := &gitlab.ListOptions{
+		Page: 1,
+		PerPage: 100,
+	}
+	for {
+		req, err := g.client.NewRequest("GET", u, opt, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := fetch(req)
+		if err != nil {
+			return err
+		}
+		if resp == nil || resp.NextPage == 0 {
+			return nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
 func parseID(id interface{}) (string, error) {
 	switch v := id.(type) {
 	case int:
@@ -42,4 +71,114 @@ func mapToSliceString(mapString map[string]bool) []string {
 		finalSlice = append(finalSlice, key)
 	}
 	return finalSlice
-}
\ No newline at end of file
+}
+func splitList(list string) []string {
+	finalSlice := make([]string, 0)
+	for _, value := range strings.Split(list, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		finalSlice = append(finalSlice, value)
+	}
+	return finalSlice
+}
+func addToList(list []string, values []string) []string {
+	for _, value := range values {
+		if inSlice(list, value) {
+			continue
+		}
+		list = append(list, value)
+	}
+	return list
+}
+func removeFromList(list []string, values []string) []string {
+	finalSlice := make([]string, 0)
+	for _, value := range list {
+		if inSlice(values, value) {
+			continue
+		}
+		finalSlice = append(finalSlice, value)
+	}
+	return finalSlice
+}
+func inSlice(list []string, search string) bool {
+	for _, value := range list {
+		if value == search {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPath returns the first path from the list matching the project path,
+// a path matches if it's the project itself, one of its parent groups or a
+// glob pattern matching it.
+func matchPath(paths []string, projectPath string) (string, bool) {
+	for _, p := range paths {
+		p = strings.Trim(p, "/")
+		if p == projectPath || strings.HasPrefix(projectPath, p + "/") {
+			return p, true
+		}
+		if ok, _ := path.Match(p, projectPath); ok {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// sendDirectMessage sends a private message to a chat user, adapters
+// resolve a channel named after a user prefixed by @ as its direct channel.
+func sendDirectMessage(username string, message string) {
+	robot.SendMessages(robot.Envelop{
+		ChannelName: "@" + username,
+		User: robot.UserEnvelop{
+			Name: username,
+		},
+	}, message)
+}
+
+// formatAge returns a short human readable duration, e.g.: 3d, 5h or 12m
+func formatAge(age time.Duration) string {
+	if age >= 24 * time.Hour {
+		return fmt.Sprintf("%dd", int(age.Hours() / 24))
+	}
+	if age >= time.Hour {
+		return fmt.Sprintf("%dh", int(age.Hours()))
+	}
+	return fmt.Sprintf("%dm", int(age.Minutes()))
+}
+func formatAgeFrom(date *time.Time) string {
+	if date == nil {
+		return "unknown age"
+	}
+	return formatAge(time.Since(*date))
+}
+// truncateText shortens a text for chat to maxLines lines and maxSize
+// characters, it cuts on a word when possible and ends with an ellipsis.
+func truncateText(text string, maxSize int, maxLines int) string {
+	text = strings.TrimSpace(strings.Replace(text, "\r\n", "\n", -1))
+	truncated := false
+	lines := strings.Split(text, "\n")
+	if len(lines) > maxLines {
+		text = strings.Join(lines[:maxLines], "\n")
+		truncated = true
+	}
+	runes := []rune(text)
+	if len(runes) > maxSize {
+		text = string(runes[:maxSize])
+		if index := strings.LastIndexAny(text, " \n"); index > maxSize / 2 {
+			text = text[:index]
+		}
+		truncated = true
+	}
+	if truncated {
+		text = strings.TrimSpace(text) + "…"
+	}
+	return text
+}
+
+// quote formats a text as a markdown quote.
+func quote(text string) string {
+	return "> " + strings.Replace(text, "\n", "\n> ", -1)
+}
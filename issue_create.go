@@ -0,0 +1,150 @@
+package gubot_gitlab
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ArthurHlt/gubot/robot"
+	"github.com/urfave/cli"
+	"github.com/xanzy/go-gitlab"
+	"net/url"
+	"strings"
+)
+
+// ISSUE_VALUE_FLAGS are flags of issue creation commands taking a value
+var ISSUE_VALUE_FLAGS = []string{"label", "assignee", "milestone", "description"}
+
+type projectMilestone struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+}
+type listMilestonesOptions struct {
+	Title string `url:"title,omitempty" json:"title,omitempty"`
+	State string `url:"state,omitempty" json:"state,omitempty"`
+}
+
+func (g GitlabApp) cmdIssueCreate(envelop robot.Envelop, c *cli.Context) error {
+	args, flags := parseFlags(c.Args(), ISSUE_VALUE_FLAGS...)
+	if len(args) < 2 {
+		fmt.Fprint(c.App.Writer, "I need a project and a title to create an issue, e.g.: " +
+			"gitlab issue create group/project \"my title\" [--label a,b] [--assignee @x] [--milestone m] [--confidential] [--queue]")
+		return nil
+	}
+	ref, err := g.createIssue(envelop, args[0], strings.Join(args[1:], " "), flags["description"], flags)
+	if err != nil {
+		fmt.Fprint(c.App.Writer, err.Error())
+		return nil
+	}
+	fmt.Fprintf(c.App.Writer, "Issue %s has been created: %s", ref, ref.WebUrl)
+	return nil
+}
+
+// createIssue creates an issue as asked by a chat user, flags are options
+// of issue create command.
+func (g GitlabApp) createIssue(envelop robot.Envelop, projectPath, title, description string, flags map[string]string) (*gitlabRef, error) {
+	ref := &gitlabRef{
+		Type: ISSUE_EVENT_NAME,
+		ProjectPath: projectPath,
+	}
+	options, err := g.actionOptions(*ref, envelop.User.Name, gitlab.GuestPermissions)
+	if err != nil {
+		return nil, err
+	}
+	opt := &gitlab.CreateIssueOptions{
+		Title: &title,
+	}
+	if description != "" {
+		opt.Description = &description
+	}
+	if flags["label"] != "" {
+		opt.Labels = gitlab.Labels(splitList(flags["label"]))
+	}
+	if flags["confidential"] != "" {
+		confidential := true
+		opt.Confidential = &confidential
+	}
+	if assignee := flags["assignee"]; assignee != "" {
+		if assignee == "me" {
+			assignee = envelop.User.Name
+		}
+		user, err := g.findUser(strings.TrimPrefix(assignee, "@"))
+		if err != nil {
+			return nil, err
+		}
+		opt.AssigneeID = &user.ID
+	}
+	if flags["milestone"] != "" {
+		milestone, err := g.findMilestone(projectPath, flags["milestone"])
+		if err != nil {
+			return nil, err
+		}
+		opt.MilestoneID = &milestone.ID
+	}
+	issue, _, err := g.client.Issues.CreateIssue(projectPath, opt, options...)
+	if err != nil {
+		return nil, errors.New("Sorry I can't create the issue: " + err.Error())
+	}
+	ref.ProjectID = issue.ProjectID
+	ref.ObjectId = issue.ID
+	ref.ObjectIid = issue.IID
+	ref.WebUrl = issue.WebURL
+	if flags["queue"] != "" {
+		g.queueCreatedIssue(*ref, issue, envelop.User.Name)
+	}
+	return ref, nil
+}
+
+// queueCreatedIssue stores an issue created from chat in queue, when the
+// webhook arrives the issue is already known and not notified again.
+func (g GitlabApp) queueCreatedIssue(ref gitlabRef, issue *gitlab.Issue, author string) {
+	projectName := ref.ProjectPath[strings.LastIndex(ref.ProjectPath, "/") + 1:]
+	notif := &GitlabNotification{
+		ProjectID: ref.ProjectID,
+		ProjectName: projectName,
+		GroupName: namespaceFromPath(ref.ProjectPath),
+		ProjectPath: ref.ProjectPath,
+		Type: ISSUE_EVENT_NAME,
+		ObjectId: ref.ObjectId,
+		ObjectIid: ref.ObjectIid,
+		ChannelName: g.channelForProject(ref.ProjectPath),
+		WebUrl: issue.WebURL,
+		AssignedUser: g.retrieveGitlabUser(issue.Assignee.Username),
+	}
+	notif.Message = g.renderTemplate(ISSUE_EVENT_NAME, notif.ChannelName, TemplateData{
+		Event: ISSUE_EVENT_NAME,
+		Project: TemplateProject{
+			Name: projectName,
+			Path: ref.ProjectPath,
+		},
+		Author: author,
+		Assignee: notif.AssignedUser,
+		Title: issue.Title,
+		Url: issue.WebURL,
+		Iid: issue.IID,
+		State: issue.State,
+		Labels: issue.Labels,
+	})
+	robot.Store().Create(notif)
+}
+func (g GitlabApp) findMilestone(pid interface{}, title string) (*projectMilestone, error) {
+	project, err := parseID(pid)
+	if err != nil {
+		return nil, err
+	}
+	u := fmt.Sprintf("projects/%s/milestones", url.QueryEscape(project))
+	req, err := g.client.NewRequest("GET", u, &listMilestonesOptions{
+		Title: title,
+		State: "active",
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	var milestones []*projectMilestone
+	_, err = g.client.Do(req, &milestones)
+	if err != nil {
+		return nil, err
+	}
+	if len(milestones) == 0 {
+		return nil, errors.New("Milestone " + title + " not found.")
+	}
+	return milestones[0], nil
+}
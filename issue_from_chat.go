@@ -0,0 +1,133 @@
+package gubot_gitlab
+
+import (
+	"fmt"
+	"github.com/ArthurHlt/gubot/robot"
+	"github.com/urfave/cli"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ISSUE_FROM_HERE_MESSAGES = 20
+
+var commandMessageRegex = regexp.MustCompile("(?i)^gitlab ")
+var chatMentionRegex = regexp.MustCompile("@([\\w.-]+)")
+
+// recordChatMessage keeps the last messages of each channel to be able to
+// create issues from a conversation, gitlab commands are not kept.
+func (g GitlabApp) recordChatMessage(envelop robot.Envelop) {
+	if envelop.ChannelName == "" || envelop.Message == "" || commandMessageRegex.MatchString(envelop.Message) {
+		return
+	}
+	robot.Store().Create(&GitlabChatMessage{
+		ChannelName: envelop.ChannelName,
+		Username: envelop.User.Name,
+		Message: envelop.Message,
+	})
+	var oldest GitlabChatMessage
+	robot.Store().Where(&GitlabChatMessage{
+		ChannelName: envelop.ChannelName,
+	}).Order("id desc").Offset(g.conf.GitlabChatHistorySize).First(&oldest)
+	if oldest.ID == 0 {
+		return
+	}
+	robot.Store().Unscoped().Where("channel_name = ? AND id <= ?", envelop.ChannelName, oldest.ID).Delete(&GitlabChatMessage{})
+}
+func (g GitlabApp) cmdIssueFromHere(envelop robot.Envelop, c *cli.Context) error {
+	args, flags := parseFlags(c.Args(), append(ISSUE_VALUE_FLAGS, "messages", "thread")...)
+	if len(args) < 2 {
+		fmt.Fprint(c.App.Writer, "I need a project and a title to create an issue from this conversation, e.g.: " +
+			"gitlab issue from-here group/project \"my title\" [--messages 20] [--thread <id|group/project!12>] [--label a,b] [--assignee @x]")
+		return nil
+	}
+	var messages []chatMessage
+	var notif *GitlabNotification
+	var err error
+	if flags["thread"] != "" {
+		notif, err = g.notifFromRef(flags["thread"])
+		if err == nil {
+			messages, err = g.threadMessages(*notif)
+		}
+	} else {
+		messages, err = g.channelMessages(envelop.ChannelName, flags["messages"])
+	}
+	if err != nil {
+		fmt.Fprint(c.App.Writer, err.Error())
+		return nil
+	}
+	if len(messages) == 0 {
+		fmt.Fprint(c.App.Writer, "I don't have any message from this conversation to put in an issue.")
+		return nil
+	}
+	description := g.transcript(envelop.ChannelName, messages)
+	if flags["description"] != "" {
+		description = flags["description"] + "\n\n" + description
+	}
+	ref, err := g.createIssue(envelop, args[0], strings.Join(args[1:], " "), description, flags)
+	if err != nil {
+		fmt.Fprint(c.App.Writer, err.Error())
+		return nil
+	}
+	message := fmt.Sprintf("Issue %s has been created from this conversation: %s", ref, ref.WebUrl)
+	fmt.Fprint(c.App.Writer, message)
+	if notif != nil {
+		g.followUp(*notif, message)
+	}
+	return nil
+}
+
+// channelMessages returns the last recorded messages of a channel, size is
+// the number of messages asked in chat.
+func (g GitlabApp) channelMessages(channelName string, size string) ([]chatMessage, error) {
+	nbMessages := ISSUE_FROM_HERE_MESSAGES
+	if size != "" {
+		var err error
+		nbMessages, err = strconv.Atoi(size)
+		if err != nil || nbMessages <= 0 {
+			return nil, fmt.Errorf("You gave me an incorrect number of messages: %s.", size)
+		}
+	}
+	var records []GitlabChatMessage
+	robot.Store().Where(&GitlabChatMessage{
+		ChannelName: channelName,
+	}).Order("id desc").Limit(nbMessages).Find(&records)
+	messages := make([]chatMessage, 0)
+	for i := len(records) - 1; i >= 0; i-- {
+		messages = append(messages, chatMessage{
+			Username: records[i].Username,
+			Message: records[i].Message,
+			CreatedAt: records[i].CreatedAt,
+		})
+	}
+	return messages, nil
+}
+
+// threadMessages reads the chat thread of a notification, it requires a
+// renderer able to read threads.
+func (g GitlabApp) threadMessages(notif GitlabNotification) ([]chatMessage, error) {
+	reader, ok := g.renderer.(threadReader)
+	if !ok || notif.ChatMessageId == "" {
+		return nil, fmt.Errorf("I can't read the thread of #%d, a chat api token is required to read threads.", notif.ID)
+	}
+	return reader.Thread(notif.ChatChannelId, notif.ChatMessageId, time.Time{})
+}
+
+// transcript formats chat messages for an issue description, chat user names
+// are replaced by gitlab ones to mention the right people.
+func (g GitlabApp) transcript(channelName string, messages []chatMessage) string {
+	text := fmt.Sprintf("Conversation from chat channel %s:\n\n", channelName)
+	for _, message := range messages {
+		body := chatMentionRegex.ReplaceAllStringFunc(message.Message, func(mention string) string {
+			return "@" + g.retrieveGitlabUserFromChat(strings.TrimPrefix(mention, "@"))
+		})
+		text += fmt.Sprintf(
+			"**@%s** (%s):\n%s\n\n",
+			g.retrieveGitlabUserFromChat(message.Username),
+			message.CreatedAt.Format("2006-01-02 15:04"),
+			quote(body),
+		)
+	}
+	return text
+}
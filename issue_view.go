@@ -0,0 +1,152 @@
+package gubot_gitlab
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"github.com/xanzy/go-gitlab"
+	"strings"
+	"time"
+)
+
+const (
+	ISSUE_SEE_DESCRIPTION_SIZE = 500
+	ISSUE_SEE_DESCRIPTION_LINES = 10
+	ISSUE_SEE_COMMENT_SIZE = 200
+	ISSUE_SEE_COMMENTS = 3
+)
+
+type issueDetails struct {
+	ID          int      `json:"id"`
+	IID         int      `json:"iid"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	State       string   `json:"state"`
+	Labels      []string `json:"labels"`
+	DueDate     string   `json:"due_date"`
+	Weight      *int     `json:"weight"`
+	WebURL      string   `json:"web_url"`
+	Author      struct {
+		Username string `json:"username"`
+	} `json:"author"`
+	Assignees   []struct {
+		Username string `json:"username"`
+	} `json:"assignees"`
+	Milestone   *struct {
+		Title string `json:"title"`
+	} `json:"milestone"`
+}
+type issueNote struct {
+	Body      string     `json:"body"`
+	System    bool       `json:"system"`
+	CreatedAt *time.Time `json:"created_at"`
+	Author    struct {
+		Username string `json:"username"`
+	} `json:"author"`
+}
+type issueNotesOptions struct {
+	gitlab.ListOptions
+	Sort    string `url:"sort,omitempty" json:"sort,omitempty"`
+	OrderBy string `url:"order_by,omitempty" json:"order_by,omitempty"`
+}
+
+func (g GitlabApp) cmdIssueSee(c *cli.Context) error {
+	ref, err := g.resolveRef(c.Args().First(), ISSUE_EVENT_NAME)
+	if err != nil {
+		fmt.Fprint(c.App.Writer, err.Error())
+		return nil
+	}
+	var issue issueDetails
+	_, err = g.DoIssueRequest(ref.pid(), ref.ObjectIid, "GET", "", nil, &issue)
+	if err != nil {
+		fmt.Fprintf(c.App.Writer, "Sorry I can't retrieve issue %s: %s", ref, err.Error())
+		return nil
+	}
+	fmt.Fprint(c.App.Writer, g.formatIssue(*ref, issue))
+	return nil
+}
+func (g GitlabApp) formatIssue(ref gitlabRef, issue issueDetails) string {
+	message := fmt.Sprintf("**[%s](%s)** (%s) from @%s\n", issue.Title, issue.WebURL, ref, issue.Author.Username)
+	if issue.Description != "" {
+		message += quote(truncateText(issue.Description, ISSUE_SEE_DESCRIPTION_SIZE, ISSUE_SEE_DESCRIPTION_LINES)) + "\n"
+	}
+	message += fmt.Sprintf("- State: %s\n", issue.State)
+	if len(issue.Labels) > 0 {
+		message += fmt.Sprintf("- Labels: %s\n", strings.Join(issue.Labels, ", "))
+	}
+	if issue.Milestone != nil {
+		message += fmt.Sprintf("- Milestone: %s\n", issue.Milestone.Title)
+	}
+	if issue.DueDate != "" {
+		message += fmt.Sprintf("- Due date: %s\n", issue.DueDate)
+	}
+	if issue.Weight != nil {
+		message += fmt.Sprintf("- Weight: %d\n", *issue.Weight)
+	}
+	assignees := make([]string, 0)
+	for _, assignee := range issue.Assignees {
+		assignees = append(assignees, "@" + assignee.Username)
+	}
+	if len(assignees) > 0 {
+		message += fmt.Sprintf("- Assignees: %s\n", strings.Join(assignees, ", "))
+	} else {
+		message += "- Assignees: nobody\n"
+	}
+
+	var closingMrs []*gitlab.MergeRequest
+	_, err := g.DoIssueRequest(ref.pid(), ref.ObjectIid, "GET", "closed_by", nil, &closingMrs)
+	if err == nil && len(closingMrs) > 0 {
+		message += "- Closed by: " + formatMergeRequestLinks(closingMrs) + "\n"
+	}
+	var relatedMrs []*gitlab.MergeRequest
+	_, err = g.DoIssueRequest(ref.pid(), ref.ObjectIid, "GET", "related_merge_requests", nil, &relatedMrs)
+	if err == nil && len(relatedMrs) > 0 {
+		message += "- Related merge requests: " + formatMergeRequestLinks(relatedMrs) + "\n"
+	}
+
+	var notes []*issueNote
+	_, err = g.DoIssueRequest(ref.pid(), ref.ObjectIid, "GET", "notes", &issueNotesOptions{
+		ListOptions: gitlab.ListOptions{PerPage: 20},
+		Sort: "desc",
+		OrderBy: "created_at",
+	}, &notes)
+	if err != nil {
+		return message
+	}
+	comments := make([]*issueNote, 0)
+	for _, note := range notes {
+		if note.System {
+			continue
+		}
+		comments = append(comments, note)
+		if len(comments) == ISSUE_SEE_COMMENTS {
+			break
+		}
+	}
+	if len(comments) == 0 {
+		return message
+	}
+	message += "\n**Last comments:**\n"
+	for i := len(comments) - 1; i >= 0; i-- {
+		message += fmt.Sprintf(
+			"- @%s (%s): %s\n",
+			comments[i].Author.Username,
+			formatAgeFrom(comments[i].CreatedAt),
+			strings.Replace(truncateText(comments[i].Body, ISSUE_SEE_COMMENT_SIZE, 1), "\n", " ", -1),
+		)
+	}
+	return message
+}
+func formatMergeRequestLinks(mrs []*gitlab.MergeRequest) string {
+	links := make([]string, 0)
+	for _, mr := range mrs {
+		links = append(links, fmt.Sprintf("[!%d %s](%s)", mr.IID, mr.Title, mr.WebURL))
+	}
+	return strings.Join(links, ", ")
+}
+
+// DoIssueRequest calls an endpoint of an issue identified by its iid, action
+// is the path after the issue (e.g.: notes) and can be empty. The response is
+// decoded in v when it's not nil.
+func (g GitlabApp) DoIssueRequest(pid interface{}, issueIid int, method, action string, opt interface{}, v interface{}, options ...gitlab.OptionFunc) (*gitlab.Response, error) {
+	return g.doProjectRequest(pid, fmt.Sprintf("issues/%d", issueIid), method, action, opt, v, options...)
+}
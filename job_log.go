@@ -0,0 +1,173 @@
+package gubot_gitlab
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ArthurHlt/gubot/robot"
+	"github.com/urfave/cli"
+	"github.com/xanzy/go-gitlab"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	JOB_LOG_LINES = 20
+	JOB_LOG_LINE_SIZE = 300
+	// JOB_LOG_CONTEXT_LINES is the number of lines shown before the first error
+	JOB_LOG_CONTEXT_LINES = 5
+)
+
+var ansiRegex = regexp.MustCompile("\x1b\\[[0-9;?]*[A-Za-z]")
+var sectionRegex = regexp.MustCompile("section_(start|end):[0-9]+:([^\r\n\x1b]*)\r?")
+// test runners report failures in upper case (e.g.: go test --- FAIL:), it's
+// only matched in upper case as fail is common in logs
+var jobErrorRegex = regexp.MustCompile("(?i:\\b(error|errors|failed|failure|fatal|exception|panic)\\b)|\\bFAIL\\b|^Traceback \\(most recent call last\\)")
+
+// traceLine is a line of a job trace without colors, Section is the name of
+// the gitlab section containing the line.
+type traceLine struct {
+	Text    string
+	Section string
+}
+
+func (g GitlabApp) cmdJobLog(envelop robot.Envelop, c *cli.Context) error {
+	args, flags := parseFlags(c.Args(), "lines")
+	if len(args) < 2 {
+		fmt.Fprint(c.App.Writer, "I need a project and a job id to show a job log, e.g.: gitlab job log group/project 1234 [--lines 20]")
+		return nil
+	}
+	jobId, err := strconv.Atoi(args[1])
+	if err != nil {
+		fmt.Fprint(c.App.Writer, "You gave me an incorrect job id.")
+		return nil
+	}
+	_, err = g.actionOptions(gitlabRef{ProjectPath: args[0]}, envelop.User.Name, gitlab.ReporterPermissions)
+	if err != nil {
+		fmt.Fprint(c.App.Writer, err.Error())
+		return nil
+	}
+	lines := JOB_LOG_LINES
+	if flags["lines"] != "" {
+		lines, err = strconv.Atoi(flags["lines"])
+		if err != nil || lines <= 0 {
+			fmt.Fprint(c.App.Writer, "You gave me an incorrect number of lines.")
+			return nil
+		}
+	}
+	trace, err := g.jobTrace(args[0], jobId)
+	if err != nil {
+		fmt.Fprintf(c.App.Writer, "Sorry I can't retrieve log of job %d: %s", jobId, err.Error())
+		return nil
+	}
+	var title, excerpt string
+	if flags["lines"] != "" {
+		title, excerpt = fmt.Sprintf("Last %d lines", lines), lastTraceLines(trace, lines)
+	} else {
+		title, excerpt = traceExcerpt(trace, lines)
+	}
+	if excerpt == "" {
+		fmt.Fprintf(c.App.Writer, "Log of job %d is empty.", jobId)
+		return nil
+	}
+	fmt.Fprintf(c.App.Writer, "**%s of job %d log:**\n```\n%s\n```", title, jobId, excerpt)
+	return nil
+}
+
+// jobLogExcerpt returns the excerpt of a job log shown in failure
+// notifications, it's empty when GitlabJobLogInNotifications is not set.
+func (g GitlabApp) jobLogExcerpt(pid interface{}, jobId int) string {
+	if !g.conf.GitlabJobLogInNotifications {
+		return ""
+	}
+	trace, err := g.jobTrace(pid, jobId)
+	if err != nil {
+		robot.Logger().Error("Error when retrieving log of job %d: %s", jobId, err.Error())
+		return ""
+	}
+	_, excerpt := traceExcerpt(trace, g.conf.GitlabJobLogLines)
+	return excerpt
+}
+func (g GitlabApp) jobTrace(pid interface{}, jobId int) ([]traceLine, error) {
+	buf := new(bytes.Buffer)
+	_, err := g.DoJobRequest(pid, jobId, "GET", "trace", nil, buf)
+	if err != nil {
+		return nil, err
+	}
+	return parseTrace(buf.String()), nil
+}
+
+// parseTrace removes colors and section markers of a raw job trace, when a
+// line is rewritten with carriage returns only its last version is kept.
+func parseTrace(raw string) []traceLine {
+	lines := make([]traceLine, 0)
+	section := ""
+	for _, rawLine := range strings.Split(raw, "\n") {
+		lineSection := section
+		for _, marker := range sectionRegex.FindAllStringSubmatch(rawLine, -1) {
+			if marker[1] == "start" {
+				section = marker[2]
+				lineSection = section
+			} else {
+				section = ""
+			}
+		}
+		text := ansiRegex.ReplaceAllString(sectionRegex.ReplaceAllString(rawLine, ""), "")
+		parts := strings.Split(strings.TrimRight(text, "\r"), "\r")
+		text = strings.TrimRight(parts[len(parts) - 1], " \t")
+		if text == "" {
+			continue
+		}
+		if runes := []rune(text); len(runes) > JOB_LOG_LINE_SIZE {
+			text = string(runes[:JOB_LOG_LINE_SIZE]) + "…"
+		}
+		lines = append(lines, traceLine{
+			Text: text,
+			Section: lineSection,
+		})
+	}
+	return lines
+}
+
+// traceExcerpt returns at most size lines of the section containing the
+// first error, starting a few lines before the error, or the last lines when
+// there is no error.
+func traceExcerpt(lines []traceLine, size int) (string, string) {
+	errorIndex := -1
+	for i, line := range lines {
+		if jobErrorRegex.MatchString(line.Text) {
+			errorIndex = i
+			break
+		}
+	}
+	if errorIndex < 0 {
+		return fmt.Sprintf("Last %d lines", size), lastTraceLines(lines, size)
+	}
+	section := lines[errorIndex].Section
+	start := errorIndex
+	for start > 0 && errorIndex - start < JOB_LOG_CONTEXT_LINES && lines[start - 1].Section == section {
+		start--
+	}
+	end := start
+	for end < len(lines) && end - start < size && lines[end].Section == section {
+		end++
+	}
+	title := "First error"
+	if section != "" {
+		title += " in section " + section
+	}
+	return title, joinTraceLines(lines[start:end])
+}
+func lastTraceLines(lines []traceLine, size int) string {
+	if len(lines) > size {
+		lines = lines[len(lines) - size:]
+	}
+	return joinTraceLines(lines)
+}
+func joinTraceLines(lines []traceLine) string {
+	texts := make([]string, len(lines))
+	for i, line := range lines {
+		texts[i] = line.Text
+	}
+	return strings.Join(texts, "\n")
+}
@@ -12,15 +12,17 @@ import (
 	"net/http"
 	"fmt"
 	"os"
+	"text/template"
 )
 
 const (
 	ROUTE_WEBHOOK = "/gitlab/webhook"
-	CRON_CREATE_HOOK_TICK int = 10
 	MERGE_REQUEST_EVENT_NAME = "merge_request"
 	ISSUE_EVENT_NAME = "issue"
 	BUILD_EVENT_NAME = "build"
 	PIPELINE_EVENT_NAME = "pipeline"
+	PUSH_EVENT_NAME = "push"
+	NOTE_EVENT_NAME = "note"
 )
 
 func init() {
@@ -38,20 +40,47 @@ func init() {
 		robot.Logger().Error("GitlabNotifyChannel conf parameter must be set.")
 		os.Exit(1)
 	}
+	client := gitlab.NewClient(robot.HttpClient(), conf.GitlabToken)
+	client.SetBaseURL(conf.GitlabBaseUrl)
+	gitlabApp := NewGitlabApp(client, conf)
+	err := gitlabApp.loadSchedules()
+	if err != nil {
+		robot.Logger().Error("GitlabWorkingHours conf parameter is invalid: %s", err.Error())
+		os.Exit(1)
+	}
+	gitlabApp.renderer, err = newRenderer(conf)
+	if err != nil {
+		robot.Logger().Error("GitlabChatRenderer conf parameter is invalid: %s", err.Error())
+		os.Exit(1)
+	}
+	err = gitlabApp.loadTemplates()
+	if err != nil {
+		robot.Logger().Error("GitlabTemplates conf parameter is invalid: %s", err.Error())
+		os.Exit(1)
+	}
+	err = gitlabApp.loadCronJobs()
+	if err != nil {
+		robot.Logger().Error("Invalid cron expression in conf: %s", err.Error())
+		os.Exit(1)
+	}
 	robot.On(robot.EVENT_ROBOT_INITIALIZED_STORE, func(emitter *emitter.Event) {
 		robot.Store().AutoMigrate(&GitlabHook{})
 		robot.Store().AutoMigrate(&GitlabNotification{})
+		robot.Store().AutoMigrate(&GitlabTeam{})
+		robot.Store().AutoMigrate(&GitlabDeferredMessage{})
+		robot.Store().AutoMigrate(&GitlabPipeline{})
+		robot.Store().AutoMigrate(&GitlabChatMessage{})
+		robot.Store().AutoMigrate(&GitlabBridge{})
+		robot.Store().AutoMigrate(&GitlabJob{})
+		robot.Store().AutoMigrate(&GitlabMergeRequestTransition{})
+		robot.Store().AutoMigrate(&GitlabMergeStatus{})
+		gitlabApp.seedTeams()
 	})
-	client := gitlab.NewClient(robot.HttpClient(), conf.GitlabToken)
-	client.SetBaseURL(conf.GitlabBaseUrl)
-	gitlabApp := NewGitlabApp(client, conf)
 	gitlabApp.Listen()
 
 	robot.Router().HandleFunc(ROUTE_WEBHOOK, gitlabApp.incomingWebhook)
 	robot.On(robot.EVENT_ROBOT_STARTED, func(emitter *emitter.Event) {
-		fmt.Println(gitlabApp.conf)
-		gitlabApp.cronHooks()
-		gitlabApp.cronNotifications()
+		gitlabApp.startCron()
 	})
 
 	confMatcher := make([]string, 0)
@@ -74,13 +103,9 @@ func init() {
 			app.UsageText = ""
 			app.Usage = "use gitlab commands directly in your favorite chat system."
 			app.Commands = gitlabApp.Commands(envelop)
-			args := make([]string, 0)
-			for _, arg := range strings.Split(envelop.Message, " ") {
-				arg = strings.TrimSpace(arg)
-				if arg == "" {
-					continue
-				}
-				args = append(args, arg)
+			args, err := tokenizeCommand(envelop.Message)
+			if err != nil {
+				return []string{err.Error()}, nil
 			}
 			app.Run(args)
 			return []string{buf.String()}, nil
@@ -96,16 +121,151 @@ type GitlabConfig struct {
 	GitlabBaseUrl        string
 	GitlabFilteredRepos  []string
 	GitlabUsersMap       map[string]string
+	GitlabTeams          []GitlabTeamConfig
+	GitlabMentionPolicies []GitlabMentionPolicy
+	GitlabMembersCacheInMinute int `cloud:",default=15"`
+	GitlabEscalationSteps []GitlabEscalationStep
+	GitlabWorkingHours   []GitlabWorkingHours
+	GitlabHooksCron      string `cloud:",default=*/10 * * * *"`
+	GitlabRemindersCron  string `cloud:",default=*/10 * * * *"`
+	GitlabDigests        []GitlabDigestConfig
+	GitlabPersonalDigests []GitlabPersonalDigestConfig
+	GitlabMergeRequestReports []GitlabMergeRequestReportConfig
+	GitlabStaleInDays    int `cloud:",default=3"`
+	GitlabStaleRules     []GitlabStaleRule
+	GitlabTemplates      []GitlabTemplateConfig
+	// GitlabChatRenderer can be markdown, slack or mattermost, slack and mattermost send
+	// rich messages through the incoming webhook GitlabChatWebhookUrl
+	GitlabChatRenderer   string `cloud:",default=markdown"`
+	GitlabChatWebhookUrl string
+	// GitlabChatApiToken enables threads and message updates through slack web api
+	// or mattermost api (GitlabChatApiUrl and GitlabChatTeam are required for mattermost)
+	GitlabChatApiToken   string
+	GitlabChatApiUrl     string
+	GitlabChatTeam       string
+	// GitlabChatHistorySize is the number of messages kept per channel to create issues from chat
+	GitlabChatHistorySize int `cloud:",default=100"`
+	// GitlabJobLogInNotifications adds the first error or the last lines of the job log in build failure notifications
+	GitlabJobLogInNotifications bool
+	GitlabJobLogLines    int `cloud:",default=20"`
+	// GitlabNoteOnClaim leaves a note in gitlab when someone claims a merge request or an issue in chat
+	GitlabNoteOnClaim    bool
+	// GitlabActAsUser performs actions asked in chat (approve, merge...) as the chat
+	// user through sudo instead of as the bot, it requires an admin token
+	GitlabActAsUser      bool
+}
+type GitlabTeamConfig struct {
+	Name    string
+	Members []string
+	Paths   []string
+	// Channel receives notifications of projects owned by the team instead of GitlabNotifyChannel
+	Channel string
+}
+
+// GitlabMentionPolicy defines who is mentioned on notifications, the first
+// policy matching the project path and the event type is used.
+// Paths and Events can be left empty to match every project or event.
+type GitlabMentionPolicy struct {
+	Paths          []string
+	Events         []string
+	// MinAccessLevel is the minimum gitlab access level (30: developer, 40: master, 50: owner)
+	MinAccessLevel int
+	// MaxMentions is the maximum number of users mentioned individually, 0 means no limit
+	MaxMentions    int
+	// OverflowMention is used in place of users when MaxMentions is reached,
+	// it can be a chat mention like @channel or "team" to mention the owning team alias
+	OverflowMention string
+	NoMentions     bool
+}
+
+// GitlabEscalationStep is applied on reminders of a notification not yet
+// assigned when it has been reminded AfterReminders times and/or is waiting
+// since AfterHours, the last step matching is used.
+type GitlabEscalationStep struct {
+	AfterReminders int
+	AfterHours     int
+	// Action can be: channel (remind in channel), maintainers (direct message
+	// to users who would be mentioned) or owners (mention owners of the project)
+	Action         string
+	// Channel where to send reminder for channel and owners actions, default to the notification channel
+	Channel        string
+}
+
+// GitlabStaleRule sets after how many days without activity in gitlab a
+// merge request or an issue is stale, the first rule matching the project
+// path and the event type is used, GitlabStaleInDays is used otherwise.
+// Paths and Events can be left empty to match every project or event.
+type GitlabStaleRule struct {
+	Paths     []string
+	Events    []string
+	AfterDays int
+}
+
+// GitlabWorkingHours defines when reminders can be sent to channels or users,
+// a working hours definition without channels and users is used by default.
+// Hours are in format HH:MM and work days are week days like mon, tue...
+type GitlabWorkingHours struct {
+	Channels     []string
+	Users        []string
+	TimeZone     string
+	Start        string
+	End          string
+	WorkDays     []string
+	QuietStart   string
+	QuietEnd     string
+	// HolidayFiles are paths to ics files, each event in these files is a day off
+	HolidayFiles []string
+}
+
+// GitlabDigestConfig sends a digest of the queue in a channel, Cron is a cron
+// expression (e.g.: "0 9 * * 1-5") evaluated in TimeZone.
+type GitlabDigestConfig struct {
+	Channel  string
+	Cron     string
+	TimeZone string
+}
+
+// GitlabPersonalDigestConfig sends to a chat user a direct message with what
+// is waiting for them in gitlab, Cron is evaluated in TimeZone.
+type GitlabPersonalDigestConfig struct {
+	User     string
+	Cron     string
+	TimeZone string
+}
+
+// GitlabMergeRequestReportConfig sends in a channel the merge request report
+// of the last Weeks (default to 1), for projects owned by Team if set. Cron is
+// evaluated in TimeZone (e.g.: "0 9 * * 1" for monday morning).
+type GitlabMergeRequestReportConfig struct {
+	Channel  string
+	Team     string
+	Weeks    int
+	Cron     string
+	TimeZone string
+}
+
+// GitlabTemplateConfig overrides message template of an event (merge_request,
+// issue, build, pipeline or queue_item), for a channel if Channel is set.
+type GitlabTemplateConfig struct {
+	Event    string
+	Channel  string
+	Template string
 }
 type GitlabApp struct {
-	client *gitlab.Client
-	conf   GitlabConfig
+	client       *gitlab.Client
+	conf         GitlabConfig
+	membersCache *membersCache
+	schedules    []*workingSchedule
+	cronJobs     []cronJob
+	templates    map[string]*template.Template
+	renderer     chatRenderer
 }
 
 func NewGitlabApp(client *gitlab.Client, conf GitlabConfig) *GitlabApp {
 	return &GitlabApp{
 		client: client,
 		conf: conf,
+		membersCache: newMembersCache(),
 	}
 }
 func (g GitlabApp) createHooks() error {
@@ -122,11 +282,14 @@ func (g GitlabApp) createHooks() error {
 		if g.isFilteredRepo(project.PathWithNamespace) {
 			continue
 		}
-		var count int
-		robot.Store().Model(&GitlabHook{}).Where(&GitlabHook{
+		var hook GitlabHook
+		robot.Store().Where(&GitlabHook{
 			ProjectID: project.ID,
-		}).Count(&count)
-		if count > 0 {
+		}).First(&hook)
+		if hook.ID != 0 {
+			if !hook.Skipped && !hook.PushEvents {
+				g.enablePushEvents(&hook)
+			}
 			continue
 		}
 
@@ -139,6 +302,8 @@ func (g GitlabApp) createHooks() error {
 			IssuesEvents: &trueBool,
 			BuildEvents: &trueBool,
 			PipelineEvents: &trueBool,
+			NoteEvents: &trueBool,
+			PushEvents: &trueBool,
 			EnableSSLVerification: &skipInsecure,
 		})
 		if resp != nil && resp.StatusCode == 403 {
@@ -157,6 +322,7 @@ func (g GitlabApp) createHooks() error {
 		robot.Store().Create(&GitlabHook{
 			ProjectID: project.ID,
 			ProjectName: project.NameWithNamespace,
+			PushEvents: true,
 		})
 	}
 	if len(listErr) > 0 {
@@ -164,6 +330,31 @@ func (g GitlabApp) createHooks() error {
 	}
 	return nil
 }
+// enableHookEvents edits the webhook of the robot on a project with opt when
+// enabled returns false, it's used to ask events to hooks created before they
+// were needed. Every hooks are edited even when one fails.
+func (g GitlabApp) enableHookEvents(projectID int, enabled func(hook *gitlab.ProjectHook) bool, opt *gitlab.EditProjectHookOptions) error {
+	hooks, _, err := g.client.Projects.ListProjectHooks(projectID, &gitlab.ListProjectHooksOptions{})
+	if err != nil {
+		return err
+	}
+	hookUrl := robot.Host() + ROUTE_WEBHOOK
+	opt.URL = &hookUrl
+	listErr := make([]string, 0)
+	for _, hook := range hooks {
+		if hook.URL != hookUrl || enabled(hook) {
+			continue
+		}
+		_, _, err := g.client.Projects.EditProjectHook(projectID, hook.ID, opt)
+		if err != nil {
+			listErr = append(listErr, err.Error())
+		}
+	}
+	if len(listErr) > 0 {
+		return errors.New(strings.Join(listErr, "\n"))
+	}
+	return nil
+}
 func (g GitlabApp) isFilteredRepo(repo string) bool {
 	for _, repoFiltered := range g.conf.GitlabFilteredRepos {
 		if repoFiltered == repo {
@@ -172,67 +363,43 @@ func (g GitlabApp) isFilteredRepo(repo string) bool {
 	}
 	return false
 }
-func (g GitlabApp) cronNotifications() {
-	go func() {
-		for {
-			g.notifAll()
-			time.Sleep(time.Duration(CRON_CREATE_HOOK_TICK) * time.Minute)
-		}
-	}()
+func (g GitlabApp) remindersJob() {
+	g.notifAll()
+	g.nudgeStale()
+	g.sendDeferredMessages()
 }
 func (g GitlabApp) notifAll() {
 	var notifs []GitlabNotification
 	robot.Store().Find(&notifs)
 	for _, notif := range notifs {
-		notifTime := notif.UpdatedAt.Add(time.Duration(g.conf.GitlabNotifyInMinute) * time.Minute)
-		if notif.AssignedUser != "" || notifTime.After(time.Now()) {
+		if notif.AssignedUser != "" || notif.IsSnoozed() || !g.isReminderDue(notif) {
 			continue
 		}
-		robot.Store().Save(&notif)
-		notif.Message = "Guys, don't forget -- " + notif.Message
-		g.notify(&notif)
-	}
-}
-func (g GitlabApp) cronHooks() {
-	go func() {
-		for {
-			err := g.createHooks()
-			if err != nil {
-				robot.Logger().Error("Error when creating required webhooks: %s", err.Error())
-			}
-			time.Sleep(time.Duration(CRON_CREATE_HOOK_TICK) * time.Minute)
+		// reminder is deferred to the next tick in working time of the channel
+		if !g.isWorkingTime(notif.ChannelName, "") {
+			continue
 		}
-	}()
+		g.remind(&notif)
+	}
 }
-func (g GitlabApp) userWithPermissionFromProjects(notif GitlabNotification) ([]string, error) {
-	var members []*gitlab.ProjectMember
-	var err error
-	if notif.ProjectID != 0 {
-		members, _, err = g.client.Projects.ListProjectMembers(notif.ProjectID, nil)
-	} else {
-		members, _, err = g.client.Projects.ListProjectMembers(notif.GroupName + "/" + notif.ProjectName, nil)
+func (g GitlabApp) hooksJob() {
+	err := g.createHooks()
+	if err != nil {
+		robot.Logger().Error("Error when creating required webhooks: %s", err.Error())
 	}
-
+}
+func (g GitlabApp) userWithPermissionFromProjects(notif GitlabNotification, minAccessLevel gitlab.AccessLevelValue) ([]string, error) {
+	members, err := g.projectMembers(notif)
 	if err != nil {
 		return []string{}, err
 	}
 
 	users := make(map[string]bool)
-	for _, member := range members {
-		if member.AccessLevel < gitlab.MasterPermissions {
-			continue
-		}
-		users[g.retrieveChatUser(member.Username)] = true
-	}
-	groupMembers, _, err := g.client.Groups.ListGroupMembers(notif.GroupName, nil)
-	if err != nil {
-		return mapToSliceString(users), nil
-	}
-	for _, member := range groupMembers {
-		if member.AccessLevel < gitlab.MasterPermissions {
+	for username, accessLevel := range members {
+		if accessLevel < minAccessLevel {
 			continue
 		}
-		users[g.retrieveChatUser(member.Username)] = true
+		users[g.retrieveChatUser(username)] = true
 	}
 	return mapToSliceString(users), nil
 }
@@ -245,6 +412,16 @@ func (g GitlabApp) retrieveChatUser(username string) string {
 	}
 	return username
 }
+
+// retrieveGitlabUserFromChat returns the gitlab user name of a chat user.
+func (g GitlabApp) retrieveGitlabUserFromChat(username string) string {
+	for usernameGitlab, usernameChat := range g.conf.GitlabUsersMap {
+		if usernameChat == username {
+			return usernameGitlab
+		}
+	}
+	return username
+}
 func (g GitlabApp) retrieveGitlabUser(username string) string {
 	if username == "" {
 		return username
@@ -257,45 +434,61 @@ func (g GitlabApp) retrieveGitlabUser(username string) string {
 	return username
 }
 func (g GitlabApp) listNotifs(where *GitlabNotification, showAssigned bool) string {
-	message := ""
 	var notifs []GitlabNotification
 	if where != nil {
 		robot.Store().Where(where).Order("project_id asc").Find(&notifs)
 	} else {
 		robot.Store().Order("project_id asc").Find(&notifs)
 	}
-	currentProject := 0
 	if len(notifs) == 0 {
 		typeNotifTxt := "issues or merge requests"
-		if where.Type != "" {
+		if where != nil && where.Type != "" {
 			typeNotifTxt = strings.Replace(where.Type, "_", " ", -1)
 		}
 		return "There is no " + typeNotifTxt + " in queue."
 	}
+	activeNotifs := make([]GitlabNotification, 0)
+	snoozedNotifs := make([]GitlabNotification, 0)
 	for _, notif := range notifs {
-		if g.isFilteredRepo(notif.GroupName + "/" + notif.ProjectName) {
+		if notif.IsSnoozed() {
+			snoozedNotifs = append(snoozedNotifs, notif)
+			continue
+		}
+		activeNotifs = append(activeNotifs, notif)
+	}
+	message := g.formatNotifs(activeNotifs, showAssigned)
+	if len(snoozedNotifs) > 0 {
+		message += "\nSnoozed:\n" + g.formatNotifs(snoozedNotifs, showAssigned)
+	}
+	return message
+}
+func (g GitlabApp) formatNotifs(notifs []GitlabNotification, showAssigned bool) string {
+	message := ""
+	currentProject := 0
+	for _, notif := range notifs {
+		if g.isFilteredRepo(notif.ProjectPath) {
 			continue
 		}
 		if currentProject != notif.ProjectID {
 			message += fmt.Sprintf("- Project [%s](%s)\n", notif.ProjectName, notif.ProjectUrl)
 			currentProject = notif.ProjectID
 		}
-		typeNotif := strings.Replace(notif.Type, "_", " ", -1)
-		message += fmt.Sprintf("  - %s: [#%d](%s) ", typeNotif, notif.ID, notif.WebUrl)
-		if showAssigned && notif.AssignedUser == "" {
-			message += fmt.Sprintf(
-				" -- this %s is not assigned, assign to you by doing `gitlab %s assign me %d`",
-				typeNotif,
-				strings.Replace(notif.Type, "_", "-", -1),
-				notif.ID,
-			)
+		item := QueueItemTemplateData{
+			ID: notif.ID,
+			Type: strings.Replace(notif.Type, "_", " ", -1),
+			Command: strings.Replace(notif.Type, "_", "-", -1),
+			Url: notif.WebUrl,
+			Age: formatAge(time.Since(notif.CreatedAt)),
+			ProjectName: notif.ProjectName,
+			AssignedUser: notif.AssignedUser,
+			ShowAssigned: showAssigned,
+			Snoozed: notif.IsSnoozed(),
 		}
-		if showAssigned && notif.AssignedUser != "" {
-			message += fmt.Sprintf(
-				" -- Assigned to %s",
-				notif.AssignedUser,
-			)
+		if item.Snoozed {
+			item.SnoozedUntil = notif.SnoozedUntil.Format(SNOOZE_DATE_FORMAT)
+			item.SnoozeReason = notif.SnoozeReason
 		}
+		message += "  - " + g.renderTemplate(QUEUE_ITEM_TEMPLATE_NAME, notif.ChannelName, item)
 		message += "\n"
 	}
 	return message
@@ -329,5 +522,11 @@ func (g GitlabApp) Listen() {
 			)
 		}
 	}()
+	go func() {
+		for event := range robot.On(robot.EVENT_ROBOT_INCOMING) {
+			gubotEvent := robot.ToGubotEvent(event)
+			g.recordChatMessage(gubotEvent.Envelop)
+		}
+	}()
 }
 
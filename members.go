@@ -0,0 +1,183 @@
+package gubot_gitlab
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ArthurHlt/gubot/robot"
+	"github.com/xanzy/go-gitlab"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type membersCacheEntry struct {
+	members   map[string]gitlab.AccessLevelValue
+	expiresAt time.Time
+}
+
+// membersCache keeps effective access level of project members by project
+// to not request gitlab on each notification.
+type membersCache struct {
+	mutex   sync.Mutex
+	entries map[string]membersCacheEntry
+}
+
+func newMembersCache() *membersCache {
+	return &membersCache{
+		entries: make(map[string]membersCacheEntry),
+	}
+}
+func (c *membersCache) get(key string) (map[string]gitlab.AccessLevelValue, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || entry.expiresAt.Before(time.Now()) {
+		return nil, false
+	}
+	return entry.members, true
+}
+func (c *membersCache) set(key string, members map[string]gitlab.AccessLevelValue, expiration time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries[key] = membersCacheEntry{
+		members: members,
+		expiresAt: time.Now().Add(expiration),
+	}
+}
+
+type projectSharedGroups struct {
+	SharedWithGroups []struct {
+		GroupID          int                     `json:"group_id"`
+		GroupName        string                  `json:"group_name"`
+		GroupFullPath    string                  `json:"group_full_path"`
+		GroupAccessLevel gitlab.AccessLevelValue `json:"group_access_level"`
+	} `json:"shared_with_groups"`
+}
+
+// projectMembers returns every gitlab username with access to the project of
+// the notification, including members inherited from parent groups and from
+// groups the project is shared with, with their effective access level.
+func (g GitlabApp) projectMembers(notif GitlabNotification) (map[string]gitlab.AccessLevelValue, error) {
+	if notif.ProjectID == 0 && notif.ProjectPath == "" {
+		return make(map[string]gitlab.AccessLevelValue), errors.New("project of the notification is unknown")
+	}
+	var pid interface{} = notif.ProjectPath
+	if notif.ProjectID != 0 {
+		pid = notif.ProjectID
+	}
+	key := fmt.Sprint(pid)
+	if members, ok := g.membersCache.get(key); ok {
+		return members, nil
+	}
+	members := make(map[string]gitlab.AccessLevelValue)
+	projectMembers, err := g.ListAllProjectMembers(pid)
+	if err != nil {
+		return members, err
+	}
+	for _, member := range projectMembers {
+		addMember(members, member.Username, member.AccessLevel)
+	}
+	// members of shared groups are not mandatory, incomplete members are not
+	// cached to retrieve them next time
+	complete := true
+	sharedGroups, _, err := g.GetProjectSharedGroups(pid)
+	if err != nil {
+		robot.Logger().Error("Error when retrieving groups project %s is shared with: %s", key, err.Error())
+		complete = false
+		sharedGroups = &projectSharedGroups{}
+	}
+	for _, sharedGroup := range sharedGroups.SharedWithGroups {
+		groupMembers, err := g.ListAllGroupMembers(sharedGroup.GroupID)
+		if err != nil {
+			robot.Logger().Error("Error when retrieving members of group %s: %s", sharedGroup.GroupFullPath, err.Error())
+			complete = false
+			continue
+		}
+		for _, member := range groupMembers {
+			accessLevel := member.AccessLevel
+			if accessLevel > sharedGroup.GroupAccessLevel {
+				accessLevel = sharedGroup.GroupAccessLevel
+			}
+			addMember(members, member.Username, accessLevel)
+		}
+	}
+	if complete {
+		g.membersCache.set(key, members, time.Duration(g.conf.GitlabMembersCacheInMinute) * time.Minute)
+	}
+	return members, nil
+}
+func (g GitlabApp) userAccessLevel(notif GitlabNotification, username string) (gitlab.AccessLevelValue, error) {
+	members, err := g.projectMembers(notif)
+	if err != nil {
+		return 0, err
+	}
+	return members[username], nil
+}
+func addMember(members map[string]gitlab.AccessLevelValue, username string, accessLevel gitlab.AccessLevelValue) {
+	if members[username] >= accessLevel {
+		return
+	}
+	members[username] = accessLevel
+}
+
+// namespaceFromPath returns the full namespace path of a project path, e.g.:
+// group/subgroup for group/subgroup/project
+func namespaceFromPath(pathWithNamespace string) string {
+	index := strings.LastIndex(pathWithNamespace, "/")
+	if index < 0 {
+		return ""
+	}
+	return pathWithNamespace[:index]
+}
+func (g GitlabApp) ListAllProjectMembers(pid interface{}) ([]*gitlab.ProjectMember, error) {
+	project, err := parseID(pid)
+	if err != nil {
+		return nil, err
+	}
+	u := fmt.Sprintf("projects/%s/members/all", url.QueryEscape(project))
+	members := make([]*gitlab.ProjectMember, 0)
+	err = g.listAllPages(u, func(req *http.Request) (*gitlab.Response, error) {
+		var pageMembers []*gitlab.ProjectMember
+		resp, err := g.client.Do(req, &pageMembers)
+		members = append(members, pageMembers...)
+		return resp, err
+	})
+	return members, err
+}
+func (g GitlabApp) ListAllGroupMembers(gid interface{}) ([]*gitlab.GroupMember, error) {
+	group, err := parseID(gid)
+	if err != nil {
+		return nil, err
+	}
+	u := fmt.Sprintf("groups/%s/members/all", url.QueryEscape(group))
+	members := make([]*gitlab.GroupMember, 0)
+	err = g.listAllPages(u, func(req *http.Request) (*gitlab.Response, error) {
+		var pageMembers []*gitlab.GroupMember
+		resp, err := g.client.Do(req, &pageMembers)
+		members = append(members, pageMembers...)
+		return resp, err
+	})
+	return members, err
+}
+func (g GitlabApp) GetProjectSharedGroups(pid interface{}, options ...gitlab.OptionFunc) (*projectSharedGroups, *gitlab.Response, error) {
+	project, err := parseID(pid)
+	if err != nil {
+		return nil, nil, err
+	}
+	u := fmt.Sprintf("projects/%s", url.QueryEscape(project))
+
+	req, err := g.client.NewRequest("GET", u, nil, options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sharedGroups := new(projectSharedGroups)
+	resp, err := g.client.Do(req, sharedGroups)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	return sharedGroups, resp, err
+}
@@ -0,0 +1,95 @@
+package gubot_gitlab
+
+import (
+	"github.com/xanzy/go-gitlab"
+	"strings"
+)
+
+const (
+	DEFAULT_OVERFLOW_MENTION = "@channel"
+	OVERFLOW_MENTION_TEAM = "team"
+)
+
+func (p GitlabMentionPolicy) match(notif GitlabNotification) bool {
+	if len(p.Events) > 0 && !inSlice(p.Events, notif.Type) {
+		return false
+	}
+	if len(p.Paths) == 0 {
+		return true
+	}
+	_, ok := matchPath(p.Paths, notif.ProjectPath)
+	return ok
+}
+func (g GitlabApp) mentionPolicy(notif GitlabNotification) GitlabMentionPolicy {
+	policy := GitlabMentionPolicy{}
+	for _, confPolicy := range g.conf.GitlabMentionPolicies {
+		if confPolicy.match(notif) {
+			policy = confPolicy
+			break
+		}
+	}
+	if policy.MinAccessLevel == 0 {
+		policy.MinAccessLevel = int(gitlab.MasterPermissions)
+	}
+	if policy.OverflowMention == "" {
+		policy.OverflowMention = DEFAULT_OVERFLOW_MENTION
+	}
+	return policy
+}
+// usersToMention returns members of the team owning the project when there
+// is one, otherwise project members, only users with at least the minimum
+// access level of the policy on the project are taken.
+func (g GitlabApp) usersToMention(notif GitlabNotification, policy GitlabMentionPolicy) ([]string, error) {
+	minAccessLevel := gitlab.AccessLevelValue(policy.MinAccessLevel)
+	team := g.teamForProject(notif.ProjectPath)
+	if team == nil || len(team.MemberList()) == 0 {
+		return g.userWithPermissionFromProjects(notif, minAccessLevel)
+	}
+	members, err := g.projectMembers(notif)
+	if err != nil {
+		return []string{}, err
+	}
+	users := make([]string, 0)
+	for _, member := range team.MemberList() {
+		// team members can be given with their gitlab or their chat user name
+		accessLevel := members[member]
+		if members[g.retrieveGitlabUserFromChat(member)] > accessLevel {
+			accessLevel = members[g.retrieveGitlabUserFromChat(member)]
+		}
+		if accessLevel < minAccessLevel {
+			continue
+		}
+		users = append(users, g.retrieveChatUser(member))
+	}
+	return users, nil
+}
+
+// mentions returns the chat mentions to prepend to a notification message
+// following the mention policy of the notification, it can be empty.
+func (g GitlabApp) mentions(notif GitlabNotification) (string, error) {
+	policy := g.mentionPolicy(notif)
+	if policy.NoMentions {
+		return "", nil
+	}
+	users, err := g.usersToMention(notif, policy)
+	if err != nil {
+		return "", err
+	}
+	if len(users) == 0 {
+		return "", nil
+	}
+	if policy.MaxMentions > 0 && len(users) > policy.MaxMentions {
+		return g.overflowMention(notif, policy), nil
+	}
+	return "@" + strings.Join(users, " @"), nil
+}
+func (g GitlabApp) overflowMention(notif GitlabNotification, policy GitlabMentionPolicy) string {
+	if policy.OverflowMention != OVERFLOW_MENTION_TEAM {
+		return policy.OverflowMention
+	}
+	team := g.teamForProject(notif.ProjectPath)
+	if team == nil {
+		return DEFAULT_OVERFLOW_MENTION
+	}
+	return "@" + team.Name
+}
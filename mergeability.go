@@ -0,0 +1,201 @@
+package gubot_gitlab
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ArthurHlt/gubot/robot"
+	"github.com/xanzy/go-gitlab"
+	"strings"
+	"time"
+)
+
+const (
+	MERGE_STATUS_CAN_BE_MERGED = "can_be_merged"
+	MERGE_STATUS_CANNOT_BE_MERGED = "cannot_be_merged"
+	MERGE_STATUS_UNCHECKED = "unchecked"
+	MERGE_STATUS_CHECKING = "checking"
+	MERGE_STATUS_CANNOT_BE_MERGED_RECHECK = "cannot_be_merged_recheck"
+	// gitlab computes merge status asynchronously after a push on the target
+	// branch, it's checked a few times before giving up
+	MERGEABILITY_CHECK_DELAY = 30 * time.Second
+	MERGEABILITY_CHECK_RETRIES = 4
+)
+
+type listOpenedMergeRequestsOptions struct {
+	gitlab.ListOptions
+	State        string `url:"state,omitempty" json:"state,omitempty"`
+	TargetBranch string `url:"target_branch,omitempty" json:"target_branch,omitempty"`
+}
+type mergeabilityMergeRequest struct {
+	IID          int    `json:"iid"`
+	Title        string `json:"title"`
+	WebURL       string `json:"web_url"`
+	MergeStatus  string `json:"merge_status"`
+	TargetBranch string `json:"target_branch"`
+	Author       struct {
+		Username string `json:"username"`
+	} `json:"author"`
+	Assignee     *struct {
+		Username string `json:"username"`
+	} `json:"assignee"`
+}
+
+// enablePushEvents asks pushes to a hook created before they were needed to
+// check merge requests after a push on their target branch.
+func (g GitlabApp) enablePushEvents(hook *GitlabHook) {
+	trueBool := true
+	err := g.enableHookEvents(hook.ProjectID, func(projectHook *gitlab.ProjectHook) bool {
+		return projectHook.PushEvents
+	}, &gitlab.EditProjectHookOptions{
+		PushEvents: &trueBool,
+	})
+	if err != nil {
+		robot.Logger().Error("Error when enabling pushes on hook of project %s: %s", hook.ProjectName, err.Error())
+		return
+	}
+	hook.PushEvents = true
+	robot.Store().Save(hook)
+}
+
+// checkMergeability checks opened merge requests targeting a branch which
+// received a push, the check runs in background as gitlab needs time to
+// compute merge status.
+func (g GitlabApp) checkMergeability(webhook []byte) {
+	var pushEvent gitlab.PushEvent
+	json.Unmarshal(webhook, &pushEvent)
+	if g.isFilteredRepo(pushEvent.Project.PathWithNamespace) || !strings.HasPrefix(pushEvent.Ref, "refs/heads/") {
+		return
+	}
+	// branch has been deleted
+	if strings.Trim(pushEvent.After, "0") == "" {
+		return
+	}
+	go g.checkMergeRequestsTargeting(pushEvent, strings.TrimPrefix(pushEvent.Ref, "refs/heads/"))
+}
+func (g GitlabApp) checkMergeRequestsTargeting(pushEvent gitlab.PushEvent, branch string) {
+	var mergeRequests []*mergeabilityMergeRequest
+	_, err := g.doProjectRequest(pushEvent.ProjectID, "merge_requests", "GET", "", &listOpenedMergeRequestsOptions{
+		ListOptions: gitlab.ListOptions{PerPage: 100},
+		State: "opened",
+		TargetBranch: branch,
+	}, &mergeRequests)
+	if err != nil {
+		robot.Logger().Error("Error when retrieving merge requests targeting %s on %s: %s", branch, pushEvent.Project.PathWithNamespace, err.Error())
+		return
+	}
+	for i := 0; i < MERGEABILITY_CHECK_RETRIES && len(mergeRequests) > 0; i++ {
+		time.Sleep(MERGEABILITY_CHECK_DELAY)
+		unchecked := make([]*mergeabilityMergeRequest, 0)
+		for _, mergeRequest := range mergeRequests {
+			if !g.checkMergeRequestMergeability(pushEvent, mergeRequest.IID) {
+				unchecked = append(unchecked, mergeRequest)
+			}
+		}
+		mergeRequests = unchecked
+	}
+}
+
+// checkMergeRequestMergeability returns false when gitlab didn't compute the
+// merge status yet. Only a merge request which could be merged before is
+// notified, the first status seen is only recorded.
+func (g GitlabApp) checkMergeRequestMergeability(pushEvent gitlab.PushEvent, iid int) bool {
+	var mergeRequest mergeabilityMergeRequest
+	_, err := g.DoMergeRequestRequest(pushEvent.ProjectID, iid, "GET", "", nil, &mergeRequest)
+	if err != nil {
+		robot.Logger().Error("Error when checking merge status of %s!%d: %s", pushEvent.Project.PathWithNamespace, iid, err.Error())
+		return true
+	}
+	if isMergeStatusPending(mergeRequest.MergeStatus) {
+		return false
+	}
+	previousStatus := recordMergeStatus(pushEvent.ProjectID, iid, mergeRequest.MergeStatus)
+	if mergeRequest.MergeStatus == MERGE_STATUS_CANNOT_BE_MERGED && previousStatus == MERGE_STATUS_CAN_BE_MERGED {
+		g.notifyUnmergeable(pushEvent, mergeRequest)
+	}
+	return true
+}
+
+// isMergeStatusPending returns true when gitlab is still computing the merge
+// status.
+func isMergeStatusPending(status string) bool {
+	return status == MERGE_STATUS_UNCHECKED || status == MERGE_STATUS_CHECKING || status == MERGE_STATUS_CANNOT_BE_MERGED_RECHECK
+}
+
+// notifyUnmergeable tells the author and the assignee of a merge request
+// that it conflicts with its target branch.
+func (g GitlabApp) notifyUnmergeable(pushEvent gitlab.PushEvent, mergeRequest mergeabilityMergeRequest) {
+	ref := gitlabRef{
+		Type: MERGE_REQUEST_EVENT_NAME,
+		ProjectID: pushEvent.ProjectID,
+		ProjectPath: pushEvent.Project.PathWithNamespace,
+		ObjectIid: mergeRequest.IID,
+		WebUrl: mergeRequest.WebURL,
+	}
+	message := fmt.Sprintf(
+		"Merge request [%s](%s) \"%s\" can't be merged anymore after a push by %s on %s. " +
+			"You can try to rebase it with: gitlab mr rebase %s (conflicts must be resolved by hand if it fails)",
+		ref,
+		mergeRequest.WebURL,
+		mergeRequest.Title,
+		pushEvent.UserName,
+		mergeRequest.TargetBranch,
+		ref,
+	)
+	var notifId uint
+	var notif GitlabNotification
+	robot.Store().Where(&GitlabNotification{
+		ProjectID: pushEvent.ProjectID,
+		ObjectIid: mergeRequest.IID,
+		Type: MERGE_REQUEST_EVENT_NAME,
+	}).First(&notif)
+	if notif.ID != 0 {
+		notifId = notif.ID
+		g.followUp(notif, "Can't be merged anymore, it conflicts with " + mergeRequest.TargetBranch + ".")
+	}
+	users := map[string]bool{
+		g.retrieveChatUser(mergeRequest.Author.Username): true,
+	}
+	if mergeRequest.Assignee != nil && mergeRequest.Assignee.Username != "" {
+		users[g.retrieveChatUser(mergeRequest.Assignee.Username)] = true
+	}
+	for user := range users {
+		g.sendDirectOrDefer(user, message, notifId)
+	}
+}
+
+// recordMergeStatus keeps the merge status of a merge request and returns
+// the previous one, it's empty when the merge request was never checked.
+func recordMergeStatus(projectID, iid int, status string) string {
+	var mergeStatus GitlabMergeStatus
+	robot.Store().Where(&GitlabMergeStatus{
+		ProjectID: projectID,
+		ObjectIid: iid,
+	}).First(&mergeStatus)
+	previousStatus := mergeStatus.Status
+	mergeStatus.ProjectID = projectID
+	mergeStatus.ObjectIid = iid
+	mergeStatus.Status = status
+	robot.Store().Save(&mergeStatus)
+	return previousStatus
+}
+
+// updateMergeStatus follows the merge status sent in merge request webhooks
+// so that a merge request fixed and conflicting again is notified again, it
+// also records merge requests never checked after a push.
+func updateMergeStatus(mergeEvent gitlab.MergeEvent) {
+	projectID := mergeEvent.ObjectAttributes.TargetProjectID
+	iid := mergeEvent.ObjectAttributes.IID
+	state := mergeEvent.ObjectAttributes.State
+	if state == "closed" || state == "merged" {
+		robot.Store().Unscoped().Where(&GitlabMergeStatus{
+			ProjectID: projectID,
+			ObjectIid: iid,
+		}).Delete(GitlabMergeStatus{})
+		return
+	}
+	status := mergeEvent.ObjectAttributes.MergeStatus
+	if status == "" || isMergeStatusPending(status) {
+		return
+	}
+	recordMergeStatus(projectID, iid, status)
+}
@@ -0,0 +1,222 @@
+package gubot_gitlab
+
+import (
+	"fmt"
+	"github.com/ArthurHlt/gubot/robot"
+	"github.com/urfave/cli"
+	"github.com/xanzy/go-gitlab"
+	"net/http"
+	"time"
+)
+
+const MINE_LIST_SIZE = 20
+
+type userMergeRequestsOptions struct {
+	gitlab.ListOptions
+	State      string `url:"state,omitempty" json:"state,omitempty"`
+	Scope      string `url:"scope,omitempty" json:"scope,omitempty"`
+	AssigneeID int    `url:"assignee_id,omitempty" json:"assignee_id,omitempty"`
+	ReviewerID int    `url:"reviewer_id,omitempty" json:"reviewer_id,omitempty"`
+}
+type userIssuesOptions struct {
+	gitlab.ListOptions
+	State      string `url:"state,omitempty" json:"state,omitempty"`
+	Scope      string `url:"scope,omitempty" json:"scope,omitempty"`
+	AssigneeID int    `url:"assignee_id,omitempty" json:"assignee_id,omitempty"`
+}
+type gitlabTodo struct {
+	ActionName string     `json:"action_name"`
+	TargetType string     `json:"target_type"`
+	TargetURL  string     `json:"target_url"`
+	Body       string     `json:"body"`
+	CreatedAt  *time.Time `json:"created_at"`
+	Project    struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+	} `json:"project"`
+}
+
+func (g GitlabApp) cmdMine(envelop robot.Envelop, c *cli.Context) error {
+	fmt.Fprint(c.App.Writer, g.myWork(envelop.User.Name))
+	return nil
+}
+func (g GitlabApp) sendPersonalDigest(username string) {
+	sendDirectMessage(username, g.myWork(username))
+}
+
+// myWork retrieves live from gitlab everything waiting for a chat user.
+func (g GitlabApp) myWork(username string) string {
+	user, err := g.findUser(username)
+	if err != nil {
+		return "Sorry I can't find you in gitlab: " + err.Error()
+	}
+	message := fmt.Sprintf("**Work for @%s**\n", username)
+
+	assignedMrs, _, err := g.ListUserMergeRequests(&userMergeRequestsOptions{
+		ListOptions: gitlab.ListOptions{PerPage: MINE_LIST_SIZE},
+		State: "opened",
+		Scope: "all",
+		AssigneeID: user.ID,
+	})
+	message += formatMineSection("Merge requests assigned to you", err, len(assignedMrs), func() string {
+		return formatMergeRequests(assignedMrs)
+	})
+
+	reviewMrs, _, err := g.ListUserMergeRequests(&userMergeRequestsOptions{
+		ListOptions: gitlab.ListOptions{PerPage: MINE_LIST_SIZE},
+		State: "opened",
+		Scope: "all",
+		ReviewerID: user.ID,
+	})
+	message += formatMineSection("Merge requests waiting for your review", err, len(reviewMrs), func() string {
+		return formatMergeRequests(reviewMrs)
+	})
+
+	issues, _, err := g.ListUserIssues(&userIssuesOptions{
+		ListOptions: gitlab.ListOptions{PerPage: MINE_LIST_SIZE},
+		State: "opened",
+		Scope: "all",
+		AssigneeID: user.ID,
+	})
+	message += formatMineSection("Issues assigned to you", err, len(issues), func() string {
+		txt := ""
+		for _, issue := range issues {
+			txt += fmt.Sprintf("- [%s](%s) (%s)\n", issue.Title, issue.WebURL, formatAgeFrom(issue.CreatedAt))
+		}
+		return txt
+	})
+
+	pipelines := g.userFailingPipelines(user.Username)
+	message += formatMineSection("Your failing pipelines", nil, len(pipelines), func() string {
+		txt := ""
+		for _, pipeline := range pipelines {
+			txt += fmt.Sprintf(
+				"- [%s](%s) on %s: [#%d](%s)\n",
+				pipeline.ProjectPath,
+				pipeline.ProjectUrl,
+				pipeline.Ref,
+				pipeline.PipelineID,
+				pipeline.WebUrl,
+			)
+		}
+		return txt
+	})
+
+	todos, _, err := g.ListUserTodos(user.Username)
+	message += formatMineSection("Your to-dos", err, len(todos), func() string {
+		txt := ""
+		for _, todo := range todos {
+			txt += fmt.Sprintf(
+				"- %s on [%s](%s) in %s (%s)\n",
+				todo.ActionName,
+				todo.Body,
+				todo.TargetURL,
+				todo.Project.PathWithNamespace,
+				formatAgeFrom(todo.CreatedAt),
+			)
+		}
+		return txt
+	})
+	return message
+}
+
+// userFailingPipelines returns pipelines triggered by the gitlab user seen
+// during last week which are still failed in gitlab, only the last pipeline
+// of a ref is taken as newer pipelines supersede older failures.
+func (g GitlabApp) userFailingPipelines(username string) []GitlabPipeline {
+	var pipelines []GitlabPipeline
+	robot.Store().Where(&GitlabPipeline{
+		Username: username,
+		Status: "failed",
+	}).Where("updated_at > ?", time.Now().Add(-7 * 24 * time.Hour)).Order("pipeline_id desc").Find(&pipelines)
+	failingPipelines := make([]GitlabPipeline, 0)
+	seenRefs := make(map[string]bool)
+	for _, pipeline := range pipelines {
+		key := pipeline.ProjectPath + "@" + pipeline.Ref
+		if seenRefs[key] {
+			continue
+		}
+		seenRefs[key] = true
+		var newerCount int
+		robot.Store().Model(&GitlabPipeline{}).Where(map[string]interface{}{
+			"project_path": pipeline.ProjectPath,
+			"ref": pipeline.Ref,
+		}).Where("pipeline_id > ?", pipeline.PipelineID).Count(&newerCount)
+		if newerCount > 0 {
+			continue
+		}
+		livePipeline, _, err := g.client.Pipelines.GetPipeline(pipeline.ProjectPath, pipeline.PipelineID)
+		if err != nil || livePipeline.Status != "failed" {
+			continue
+		}
+		failingPipelines = append(failingPipelines, pipeline)
+	}
+	return failingPipelines
+}
+func (g GitlabApp) ListUserMergeRequests(opt *userMergeRequestsOptions, options ...gitlab.OptionFunc) ([]*gitlab.MergeRequest, *gitlab.Response, error) {
+	req, err := g.client.NewRequest("GET", "merge_requests", opt, options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var mrs []*gitlab.MergeRequest
+	resp, err := g.client.Do(req, &mrs)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	return mrs, resp, err
+}
+func (g GitlabApp) ListUserIssues(opt *userIssuesOptions, options ...gitlab.OptionFunc) ([]*gitlab.Issue, *gitlab.Response, error) {
+	req, err := g.client.NewRequest("GET", "issues", opt, options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var issues []*gitlab.Issue
+	resp, err := g.client.Do(req, &issues)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	return issues, resp, err
+}
+
+// ListUserTodos retrieves pending to-dos of a gitlab user, gitlab only gives
+// to-dos of the authenticated user so it requires an admin token to sudo.
+func (g GitlabApp) ListUserTodos(username string, options ...gitlab.OptionFunc) ([]*gitlabTodo, *gitlab.Response, error) {
+	options = append(options, withSudo(username))
+	req, err := g.client.NewRequest("GET", "todos", &gitlab.ListOptions{PerPage: MINE_LIST_SIZE}, options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var todos []*gitlabTodo
+	resp, err := g.client.Do(req, &todos)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	return todos, resp, err
+}
+func withSudo(username string) gitlab.OptionFunc {
+	return func(req *http.Request) error {
+		req.Header.Set("Sudo", username)
+		return nil
+	}
+}
+func formatMineSection(title string, err error, count int, format func() string) string {
+	if err != nil {
+		return fmt.Sprintf("\n**%s:** I can't retrieve them: %s\n", title, err.Error())
+	}
+	if count == 0 {
+		return fmt.Sprintf("\n**%s:** nothing\n", title)
+	}
+	return fmt.Sprintf("\n**%s:**\n", title) + format()
+}
+func formatMergeRequests(mrs []*gitlab.MergeRequest) string {
+	txt := ""
+	for _, mr := range mrs {
+		txt += fmt.Sprintf("- [%s](%s) (%s)\n", mr.Title, mr.WebURL, formatAgeFrom(mr.CreatedAt))
+	}
+	return txt
+}
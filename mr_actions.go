@@ -0,0 +1,215 @@
+package gubot_gitlab
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ArthurHlt/gubot/robot"
+	"github.com/urfave/cli"
+	"github.com/xanzy/go-gitlab"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	MR_ACTION_APPROVE = "approve"
+	MR_ACTION_UNAPPROVE = "unapprove"
+	MR_ACTION_MERGE = "merge"
+	MR_ACTION_REBASE = "rebase"
+	MR_ACTION_CLOSE = "close"
+	MR_ACTION_REOPEN = "reopen"
+)
+
+type acceptMergeRequestOptions struct {
+	Squash                    bool `url:"squash,omitempty" json:"squash,omitempty"`
+	ShouldRemoveSourceBranch  bool `url:"should_remove_source_branch,omitempty" json:"should_remove_source_branch,omitempty"`
+	MergeWhenPipelineSucceeds bool `url:"merge_when_pipeline_succeeds,omitempty" json:"merge_when_pipeline_succeeds,omitempty"`
+}
+type mergeRequestStateOptions struct {
+	StateEvent string `url:"state_event" json:"state_event"`
+}
+type mergeRequestApprovals struct {
+	ApprovalsRequired int `json:"approvals_required"`
+	ApprovalsLeft     int `json:"approvals_left"`
+	ApprovedBy        []struct {
+		User struct {
+			Username string `json:"username"`
+		} `json:"user"`
+	} `json:"approved_by"`
+}
+type pipelineInfo struct {
+	ID     int    `json:"id"`
+	Status string `json:"status"`
+	Ref    string `json:"ref"`
+	Sha    string `json:"sha"`
+	WebURL string `json:"web_url"`
+}
+
+func (g GitlabApp) cmdMergeRequestAction(envelop robot.Envelop, c *cli.Context, action string) error {
+	args, flags := parseFlags(c.Args())
+	if len(args) == 0 {
+		fmt.Fprintf(c.App.Writer, "I need a merge request reference to %s it (e.g.: gitlab mr %s group/project!12).", action, action)
+		return nil
+	}
+	// an approval made with the robot token would be the robot one and would
+	// let anyone meet approval rules
+	if (action == MR_ACTION_APPROVE || action == MR_ACTION_UNAPPROVE) && !g.conf.GitlabActAsUser {
+		fmt.Fprintf(c.App.Writer, "Sorry I can't %s merge requests, act as user (GitlabActAsUser) is required to %s as yourself.", action, action)
+		return nil
+	}
+	ref, err := g.resolveRef(args[0], MERGE_REQUEST_EVENT_NAME)
+	if err != nil {
+		fmt.Fprint(c.App.Writer, err.Error())
+		return nil
+	}
+	options, err := g.actionOptions(*ref, envelop.User.Name, g.mergeRequestActionLevel(action))
+	if err != nil {
+		fmt.Fprint(c.App.Writer, err.Error())
+		return nil
+	}
+	message, err := g.mergeRequestAction(*ref, action, flags, options...)
+	if err != nil {
+		fmt.Fprintf(c.App.Writer, "Sorry I can't %s merge request %s: %s", action, ref, err.Error())
+		return nil
+	}
+	fmt.Fprint(c.App.Writer, message)
+	if ref.Notif != nil {
+		g.followUp(*ref.Notif, message + " Asked by @" + envelop.User.Name + " from chat.")
+	}
+	return nil
+}
+
+// mergeRequestActionLevel returns the access level required for an action,
+// without GitlabActAsUser actions are made with the robot token and gitlab
+// can't enforce protected branches for the chat user, merging, closing and
+// reopening then require master permissions (approvals are refused).
+func (g GitlabApp) mergeRequestActionLevel(action string) gitlab.AccessLevelValue {
+	if g.conf.GitlabActAsUser {
+		return gitlab.DeveloperPermissions
+	}
+	switch action {
+	case MR_ACTION_MERGE, MR_ACTION_CLOSE, MR_ACTION_REOPEN:
+		return gitlab.MasterPermissions
+	}
+	return gitlab.DeveloperPermissions
+}
+
+// actionOptions checks that the chat user has at least the access level
+// required on the project and returns options to act as the user when
+// GitlabActAsUser is set.
+func (g GitlabApp) actionOptions(ref gitlabRef, username string, minAccessLevel gitlab.AccessLevelValue) ([]gitlab.OptionFunc, error) {
+	user, err := g.findUser(username)
+	if err != nil {
+		return nil, err
+	}
+	accessLevel, err := g.userAccessLevel(ref.project(), user.Username)
+	if err != nil {
+		return nil, err
+	}
+	if accessLevel < minAccessLevel {
+		return nil, errors.New("Nice try but you don't have the correct permission.")
+	}
+	if !g.conf.GitlabActAsUser {
+		return []gitlab.OptionFunc{}, nil
+	}
+	return []gitlab.OptionFunc{withSudo(user.Username)}, nil
+}
+
+// mergeRequestAction performs the action in gitlab and returns a message
+// describing the result, when gitlab refuses the error gives the reason.
+func (g GitlabApp) mergeRequestAction(ref gitlabRef, action string, flags map[string]string, options ...gitlab.OptionFunc) (string, error) {
+	var resp *gitlab.Response
+	var err error
+	var message string
+	switch action {
+	case MR_ACTION_APPROVE:
+		resp, err = g.DoMergeRequestRequest(ref.pid(), ref.ObjectIid, "POST", "approve", nil, nil, options...)
+		message = fmt.Sprintf("Merge request %s has been approved.", ref)
+	case MR_ACTION_UNAPPROVE:
+		resp, err = g.DoMergeRequestRequest(ref.pid(), ref.ObjectIid, "POST", "unapprove", nil, nil, options...)
+		message = fmt.Sprintf("Approval on merge request %s has been removed.", ref)
+	case MR_ACTION_MERGE:
+		opt := &acceptMergeRequestOptions{
+			Squash: flags["squash"] != "",
+			ShouldRemoveSourceBranch: flags["remove-source-branch"] != "",
+			MergeWhenPipelineSucceeds: flags["when-pipeline-succeeds"] != "",
+		}
+		resp, err = g.DoMergeRequestRequest(ref.pid(), ref.ObjectIid, "PUT", "merge", opt, nil, options...)
+		message = fmt.Sprintf("Merge request %s has been merged.", ref)
+		if opt.MergeWhenPipelineSucceeds {
+			message = fmt.Sprintf("Merge request %s will be merged when its pipeline succeeds.", ref)
+		}
+	case MR_ACTION_REBASE:
+		resp, err = g.DoMergeRequestRequest(ref.pid(), ref.ObjectIid, "PUT", "rebase", nil, nil, options...)
+		message = fmt.Sprintf("Rebase of merge request %s has been started.", ref)
+	case MR_ACTION_CLOSE, MR_ACTION_REOPEN:
+		resp, err = g.DoMergeRequestRequest(ref.pid(), ref.ObjectIid, "PUT", "", &mergeRequestStateOptions{
+			StateEvent: action,
+		}, nil, options...)
+		message = fmt.Sprintf("Merge request %s has been %sed.", ref, strings.TrimSuffix(action, "e"))
+	default:
+		return "", fmt.Errorf("unknown action '%s'", action)
+	}
+	if err != nil {
+		return "", g.refusalReason(ref, action, resp, err)
+	}
+	return message, nil
+}
+
+// refusalReason turns a gitlab refusal into a readable reason, when merging
+// is not allowed the merge request is checked to find what blocks it.
+func (g GitlabApp) refusalReason(ref gitlabRef, action string, resp *gitlab.Response, err error) error {
+	statusCode := 0
+	if resp != nil && resp.Response != nil {
+		statusCode = resp.StatusCode
+	}
+	switch statusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return errors.New("gitlab says I'm not allowed to do it.")
+	case http.StatusNotFound:
+		return errors.New("gitlab can't find it.")
+	case http.StatusConflict:
+		if action == MR_ACTION_REBASE {
+			return errors.New("a rebase is already in progress.")
+		}
+		return errors.New("it has been updated in the meantime, try again.")
+	case http.StatusMethodNotAllowed, http.StatusNotAcceptable:
+		blockers := g.mergeBlockers(ref)
+		if len(blockers) > 0 {
+			return errors.New(strings.Join(blockers, ", ") + ".")
+		}
+	}
+	return err
+}
+
+// mergeBlockers retrieves live from gitlab what prevents a merge request to
+// be merged.
+func (g GitlabApp) mergeBlockers(ref gitlabRef) []string {
+	readiness, err := g.mergeReadiness(ref)
+	if err != nil {
+		return []string{}
+	}
+	return readiness.blockers(ref)
+}
+func (g GitlabApp) mergeRequestLastPipeline(ref gitlabRef) *pipelineInfo {
+	var pipelines []*pipelineInfo
+	_, err := g.DoMergeRequestRequest(ref.pid(), ref.ObjectIid, "GET", "pipelines", nil, &pipelines)
+	if err != nil || len(pipelines) == 0 {
+		return nil
+	}
+	return pipelines[0]
+}
+
+// DoMergeRequestRequest calls an endpoint of a merge request identified by
+// its iid, action is the path after the merge request (e.g.: approve) and
+// can be empty. The response is decoded in v when it's not nil.
+func (g GitlabApp) DoMergeRequestRequest(pid interface{}, mrIid int, method, action string, opt interface{}, v interface{}, options ...gitlab.OptionFunc) (*gitlab.Response, error) {
+	return g.doProjectRequest(pid, fmt.Sprintf("merge_requests/%d", mrIid), method, action, opt, v, options...)
+}
+func mergeRequestPath(pid interface{}, mrIid int) (string, error) {
+	project, err := parseID(pid)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("projects/%s/merge_requests/%d", url.QueryEscape(project), mrIid), nil
+}
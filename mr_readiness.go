@@ -0,0 +1,221 @@
+package gubot_gitlab
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"github.com/xanzy/go-gitlab"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type mergeRequestDiscussion struct {
+	ID    string `json:"id"`
+	Notes []struct {
+		Resolvable bool `json:"resolvable"`
+		Resolved   bool `json:"resolved"`
+	} `json:"notes"`
+}
+type projectMergeSettings struct {
+	OnlyAllowMergeIfAllDiscussionsAreResolved bool `json:"only_allow_merge_if_all_discussions_are_resolved"`
+}
+type mergeRequestChanges struct {
+	ChangesCount string `json:"changes_count"`
+	Changes      []struct {
+		Diff string `json:"diff"`
+	} `json:"changes"`
+}
+
+// mergeReadiness gathers live data from gitlab needed to know if a merge
+// request can be merged, Approvals and Pipeline are nil when not available.
+type mergeReadiness struct {
+	MergeRequest              gitlab.MergeRequest
+	Approvals                 *mergeRequestApprovals
+	Pipeline                  *pipelineInfo
+	UnresolvedDiscussions     int
+	// DiscussionsMustBeResolved is set when the project only allows merge
+	// once every discussions are resolved
+	DiscussionsMustBeResolved bool
+	ChangedFiles              string
+	Additions                 int
+	Deletions                 int
+}
+
+func (g GitlabApp) cmdMergeRequestSee(c *cli.Context) error {
+	ref, err := g.resolveRef(c.Args().First(), MERGE_REQUEST_EVENT_NAME)
+	if err != nil {
+		fmt.Fprint(c.App.Writer, err.Error())
+		return nil
+	}
+	readiness, err := g.mergeReadiness(*ref)
+	if err != nil {
+		fmt.Fprintf(c.App.Writer, "Sorry I can't retrieve merge request %s: %s", ref, err.Error())
+		return nil
+	}
+	fmt.Fprint(c.App.Writer, readiness.format(*ref))
+	return nil
+}
+func (g GitlabApp) mergeReadiness(ref gitlabRef) (*mergeReadiness, error) {
+	readiness := &mergeReadiness{}
+	_, err := g.DoMergeRequestRequest(ref.pid(), ref.ObjectIid, "GET", "", nil, &readiness.MergeRequest)
+	if err != nil {
+		return nil, err
+	}
+	var approvals mergeRequestApprovals
+	_, err = g.DoMergeRequestRequest(ref.pid(), ref.ObjectIid, "GET", "approvals", nil, &approvals)
+	if err == nil {
+		readiness.Approvals = &approvals
+	}
+	readiness.Pipeline = g.mergeRequestLastPipeline(ref)
+	settings, err := g.GetProjectMergeSettings(ref.pid())
+	if err == nil {
+		readiness.DiscussionsMustBeResolved = settings.OnlyAllowMergeIfAllDiscussionsAreResolved
+	}
+
+	discussions, err := g.ListAllMergeRequestDiscussions(ref.pid(), ref.ObjectIid)
+	if err != nil {
+		return nil, err
+	}
+	for _, discussion := range discussions {
+		for _, note := range discussion.Notes {
+			if note.Resolvable && !note.Resolved {
+				readiness.UnresolvedDiscussions++
+				break
+			}
+		}
+	}
+
+	var changes mergeRequestChanges
+	_, err = g.DoMergeRequestRequest(ref.pid(), ref.ObjectIid, "GET", "changes", nil, &changes)
+	if err != nil {
+		return nil, err
+	}
+	readiness.ChangedFiles = changes.ChangesCount
+	for _, change := range changes.Changes {
+		for _, line := range strings.Split(change.Diff, "\n") {
+			if strings.HasPrefix(line, "+") && !strings.HasPrefix(line, "+++") {
+				readiness.Additions++
+			}
+			if strings.HasPrefix(line, "-") && !strings.HasPrefix(line, "---") {
+				readiness.Deletions++
+			}
+		}
+	}
+	return readiness, nil
+}
+
+// blockers returns what prevents the merge request to be merged, it's
+// ready to merge when there is none.
+func (r mergeReadiness) blockers(ref gitlabRef) []string {
+	blockers := make([]string, 0)
+	mr := r.MergeRequest
+	if mr.State != "opened" {
+		blockers = append(blockers, "it's " + mr.State)
+	}
+	if mr.WorkInProgress {
+		blockers = append(blockers, "it's a draft")
+	}
+	if mr.MergeStatus == MERGE_STATUS_CANNOT_BE_MERGED {
+		blockers = append(blockers, fmt.Sprintf("it has conflicts with %s (try `gitlab mr rebase %s`)", mr.TargetBranch, ref))
+	}
+	if isMergeStatusPending(mr.MergeStatus) {
+		blockers = append(blockers, "gitlab is still checking for conflicts")
+	}
+	if r.Approvals != nil && r.Approvals.ApprovalsLeft > 0 {
+		blockers = append(blockers, fmt.Sprintf("it still needs %d approval(s)", r.Approvals.ApprovalsLeft))
+	}
+	if r.Pipeline != nil {
+		switch r.Pipeline.Status {
+		case "running", "pending", "created":
+			blockers = append(blockers, "its pipeline is still running")
+		case "failed", "canceled":
+			blockers = append(blockers, "its pipeline " + r.Pipeline.Status)
+		}
+	}
+	if r.UnresolvedDiscussions > 0 && r.DiscussionsMustBeResolved {
+		blockers = append(blockers, fmt.Sprintf("%d unresolved discussion(s)", r.UnresolvedDiscussions))
+	}
+	return blockers
+}
+func (r mergeReadiness) format(ref gitlabRef) string {
+	mr := r.MergeRequest
+	message := fmt.Sprintf("**[%s](%s)** (%s) from @%s\n", mr.Title, mr.WebURL, ref, mr.Author.Username)
+	state := mr.State
+	if mr.WorkInProgress {
+		state += ", draft"
+	}
+	message += fmt.Sprintf("- State: %s\n", state)
+	message += fmt.Sprintf("- Branches: %s into %s\n", mr.SourceBranch, mr.TargetBranch)
+	if r.Pipeline != nil {
+		message += fmt.Sprintf("- Pipeline: [%s](%s)\n", r.Pipeline.Status, r.Pipeline.WebURL)
+	} else {
+		message += "- Pipeline: none\n"
+	}
+	if r.Approvals != nil {
+		approvers := make([]string, 0)
+		for _, approver := range r.Approvals.ApprovedBy {
+			approvers = append(approvers, "@" + approver.User.Username)
+		}
+		approvals := fmt.Sprintf("- Approvals: %d/%d", len(approvers), r.Approvals.ApprovalsRequired)
+		if len(approvers) > 0 {
+			approvals += " by " + strings.Join(approvers, ", ")
+		}
+		message += approvals + "\n"
+	}
+	conflicts := "none"
+	if mr.MergeStatus == MERGE_STATUS_CANNOT_BE_MERGED {
+		conflicts = "yes"
+	}
+	if isMergeStatusPending(mr.MergeStatus) {
+		conflicts = "still checking"
+	}
+	message += fmt.Sprintf("- Conflicts: %s\n", conflicts)
+	message += fmt.Sprintf("- Unresolved discussions: %d\n", r.UnresolvedDiscussions)
+	message += fmt.Sprintf("- Diff: %s file(s), +%d -%d\n", r.ChangedFiles, r.Additions, r.Deletions)
+	if len(mr.Labels) > 0 {
+		message += fmt.Sprintf("- Labels: %s\n", strings.Join(mr.Labels, ", "))
+	}
+	if mr.Milestone != nil {
+		message += fmt.Sprintf("- Milestone: %s\n", mr.Milestone.Title)
+	}
+	blockers := r.blockers(ref)
+	if len(blockers) == 0 {
+		return message + "\n**Verdict: ready to merge**"
+	}
+	return message + "\n**Verdict: blocked by " + strings.Join(blockers, ", ") + "**"
+}
+func (g GitlabApp) ListAllMergeRequestDiscussions(pid interface{}, mrIid int) ([]*mergeRequestDiscussion, error) {
+	u, err := mergeRequestPath(pid, mrIid)
+	if err != nil {
+		return nil, err
+	}
+	allDiscussions := make([]*mergeRequestDiscussion, 0)
+	err = g.listAllPages(u + "/discussions", func(req *http.Request) (*gitlab.Response, error) {
+		var discussions []*mergeRequestDiscussion
+		resp, err := g.client.Do(req, &discussions)
+		allDiscussions = append(allDiscussions, discussions...)
+		return resp, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return allDiscussions, nil
+}
+func (g GitlabApp) GetProjectMergeSettings(pid interface{}, options ...gitlab.OptionFunc) (*projectMergeSettings, error) {
+	project, err := parseID(pid)
+	if err != nil {
+		return nil, err
+	}
+	req, err := g.client.NewRequest("GET", "projects/" + url.QueryEscape(project), nil, options)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := new(projectMergeSettings)
+	_, err = g.client.Do(req, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
@@ -0,0 +1,279 @@
+package gubot_gitlab
+
+import (
+	"fmt"
+	"github.com/ArthurHlt/gubot/robot"
+	"github.com/urfave/cli"
+	"github.com/xanzy/go-gitlab"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	MR_TRANSITION_OPENED = "opened"
+	MR_TRANSITION_REOPENED = "reopened"
+	MR_TRANSITION_ASSIGNED = "assigned"
+	MR_TRANSITION_MERGED = "merged"
+	MR_TRANSITION_CLOSED = "closed"
+	MR_REPORT_WEEKS = 4
+)
+
+// mergeRequestLifecycle is built from transitions of a merge request, dates
+// are nil when the transition was not seen by the robot.
+type mergeRequestLifecycle struct {
+	ProjectPath     string
+	OpenedAt        *time.Time
+	FirstAssignedAt *time.Time
+	MergedAt        *time.Time
+	// Reviewer is the last gitlab user assigned before merge
+	Reviewer        string
+}
+
+// mergeRequestMetrics are computed on a set of merge requests, times are in
+// seconds.
+type mergeRequestMetrics struct {
+	Opened        int
+	Merged        int
+	TimesToAssign []float64
+	TimesToMerge  []float64
+}
+
+func (m *mergeRequestMetrics) add(lifecycle mergeRequestLifecycle, since time.Time) {
+	if lifecycle.OpenedAt != nil && lifecycle.OpenedAt.After(since) {
+		m.Opened++
+		if lifecycle.FirstAssignedAt != nil {
+			m.TimesToAssign = append(m.TimesToAssign, lifecycle.FirstAssignedAt.Sub(*lifecycle.OpenedAt).Seconds())
+		}
+	}
+	if lifecycle.MergedAt != nil && lifecycle.MergedAt.After(since) {
+		m.Merged++
+		if lifecycle.OpenedAt != nil {
+			m.TimesToMerge = append(m.TimesToMerge, lifecycle.MergedAt.Sub(*lifecycle.OpenedAt).Seconds())
+		}
+	}
+}
+func (m mergeRequestMetrics) row(name string) []string {
+	return []string{
+		name,
+		strconv.Itoa(m.Opened),
+		strconv.Itoa(m.Merged),
+		formatMedianAge(m.TimesToAssign),
+		formatMedianAge(m.TimesToMerge),
+	}
+}
+
+// recordMergeRequestTransition keeps the transitions seen in a merge request
+// webhook.
+func (g GitlabApp) recordMergeRequestTransition(mergeEvent gitlab.MergeEvent) {
+	projectID := mergeEvent.ObjectAttributes.TargetProjectID
+	projectPath := mergeEvent.Project.PathWithNamespace
+	iid := mergeEvent.ObjectAttributes.IID
+	action := ""
+	switch mergeEvent.ObjectAttributes.Action {
+	case "open":
+		action = MR_TRANSITION_OPENED
+	case "reopen":
+		action = MR_TRANSITION_REOPENED
+	case "merge":
+		action = MR_TRANSITION_MERGED
+	case "close":
+		action = MR_TRANSITION_CLOSED
+	}
+	if action != "" {
+		robot.Store().Create(&GitlabMergeRequestTransition{
+			ProjectID: projectID,
+			ProjectPath: projectPath,
+			ObjectIid: iid,
+			Action: action,
+			Username: mergeEvent.User.Username,
+		})
+	}
+	if mergeEvent.Assignee.Username != "" && mergeEvent.ObjectAttributes.State == "opened" {
+		recordMergeRequestAssignment(projectID, projectPath, iid, mergeEvent.Assignee.Username)
+	}
+}
+
+// recordMergeRequestAssignment keeps an assignment unless the gitlab user is
+// already the last assignee of the merge request.
+func recordMergeRequestAssignment(projectID int, projectPath string, iid int, username string) {
+	var last GitlabMergeRequestTransition
+	robot.Store().Where(&GitlabMergeRequestTransition{
+		ProjectID: projectID,
+		ObjectIid: iid,
+		Action: MR_TRANSITION_ASSIGNED,
+	}).Order("created_at desc").First(&last)
+	if last.ID != 0 && last.Username == username {
+		return
+	}
+	robot.Store().Create(&GitlabMergeRequestTransition{
+		ProjectID: projectID,
+		ProjectPath: projectPath,
+		ObjectIid: iid,
+		Action: MR_TRANSITION_ASSIGNED,
+		Username: username,
+	})
+}
+func (g GitlabApp) cmdReportMergeRequest(c *cli.Context) error {
+	_, flags := parseFlags(c.Args(), "team", "weeks")
+	weeks := MR_REPORT_WEEKS
+	if flags["weeks"] != "" {
+		var err error
+		weeks, err = strconv.Atoi(flags["weeks"])
+		if err != nil || weeks <= 0 {
+			fmt.Fprint(c.App.Writer, "You gave me an incorrect number of weeks.")
+			return nil
+		}
+	}
+	var team *GitlabTeam
+	if flags["team"] != "" {
+		team = g.findTeam(flags["team"])
+		if team == nil {
+			fmt.Fprintf(c.App.Writer, "Team %s not found.", flags["team"])
+			return nil
+		}
+	}
+	fmt.Fprint(c.App.Writer, g.mergeRequestReport(team, weeks))
+	return nil
+}
+
+// sendMergeRequestReport sends the merge request report in a channel.
+func (g GitlabApp) sendMergeRequestReport(channelName, teamName string, weeks int) {
+	var team *GitlabTeam
+	if teamName != "" {
+		team = g.findTeam(teamName)
+		if team == nil {
+			robot.Logger().Error("Team %s of merge request report not found.", teamName)
+			return
+		}
+	}
+	robot.SendMessages(robot.Envelop{
+		ChannelName: channelName,
+	}, g.mergeRequestReport(team, weeks))
+}
+
+// mergeRequestReport shows time to first assignment, time to merge and
+// throughput per project and per reviewer, only projects owned by the team
+// are taken when team is not nil.
+func (g GitlabApp) mergeRequestReport(team *GitlabTeam, weeks int) string {
+	since := time.Now().AddDate(0, 0, -7 * weeks)
+	title := fmt.Sprintf("**Merge requests during the last %d week(s)", weeks)
+	if team != nil {
+		title += " for team " + team.Name
+	}
+	title += "**\n"
+
+	lifecycles := mergeRequestLifecycles(since)
+	total := &mergeRequestMetrics{}
+	byProject := make(map[string]*mergeRequestMetrics)
+	byReviewer := make(map[string]*mergeRequestMetrics)
+	for _, lifecycle := range lifecycles {
+		if g.isFilteredRepo(lifecycle.ProjectPath) {
+			continue
+		}
+		if team != nil {
+			if _, ok := team.ownPath(lifecycle.ProjectPath); !ok {
+				continue
+			}
+		}
+		total.add(lifecycle, since)
+		if _, ok := byProject[lifecycle.ProjectPath]; !ok {
+			byProject[lifecycle.ProjectPath] = &mergeRequestMetrics{}
+		}
+		byProject[lifecycle.ProjectPath].add(lifecycle, since)
+		if lifecycle.Reviewer == "" {
+			continue
+		}
+		reviewer := "@" + g.retrieveChatUser(lifecycle.Reviewer)
+		if _, ok := byReviewer[reviewer]; !ok {
+			byReviewer[reviewer] = &mergeRequestMetrics{}
+		}
+		byReviewer[reviewer].add(lifecycle, since)
+	}
+	if total.Opened == 0 && total.Merged == 0 {
+		return title + "No merge requests opened or merged."
+	}
+	message := title + fmt.Sprintf(
+		"%d opened, %d merged, median time to first assignment: %s, median time to merge: %s\n",
+		total.Opened,
+		total.Merged,
+		formatMedianAge(total.TimesToAssign),
+		formatMedianAge(total.TimesToMerge),
+	)
+	header := []string{"", "Opened", "Merged", "To assign", "To merge"}
+	message += "\n**Per project:**\n```\n" + formatTable(metricsRows(header, byProject)) + "```\n"
+	if len(byReviewer) > 0 {
+		message += "\n**Per reviewer:**\n```\n" + formatTable(metricsRows(header, byReviewer)) + "```"
+	}
+	return message
+}
+
+// mergeRequestLifecycles builds lifecycles of merge requests with a
+// transition since the given time.
+func mergeRequestLifecycles(since time.Time) []mergeRequestLifecycle {
+	var recent []GitlabMergeRequestTransition
+	robot.Store().Where("created_at > ?", since).Find(&recent)
+	seen := make(map[string]bool)
+	lifecycles := make([]mergeRequestLifecycle, 0)
+	for _, transition := range recent {
+		key := fmt.Sprintf("%d!%d", transition.ProjectID, transition.ObjectIid)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		var transitions []GitlabMergeRequestTransition
+		robot.Store().Where(&GitlabMergeRequestTransition{
+			ProjectID: transition.ProjectID,
+			ObjectIid: transition.ObjectIid,
+		}).Order("created_at asc").Find(&transitions)
+		lifecycles = append(lifecycles, newMergeRequestLifecycle(transitions))
+	}
+	return lifecycles
+}
+func newMergeRequestLifecycle(transitions []GitlabMergeRequestTransition) mergeRequestLifecycle {
+	lifecycle := mergeRequestLifecycle{}
+	for i, transition := range transitions {
+		at := transitions[i].CreatedAt
+		lifecycle.ProjectPath = transition.ProjectPath
+		switch transition.Action {
+		case MR_TRANSITION_OPENED:
+			if lifecycle.OpenedAt == nil {
+				lifecycle.OpenedAt = &at
+			}
+		case MR_TRANSITION_ASSIGNED:
+			if lifecycle.MergedAt != nil {
+				continue
+			}
+			if lifecycle.FirstAssignedAt == nil && lifecycle.OpenedAt != nil {
+				lifecycle.FirstAssignedAt = &at
+			}
+			lifecycle.Reviewer = transition.Username
+		case MR_TRANSITION_MERGED:
+			lifecycle.MergedAt = &at
+		}
+	}
+	return lifecycle
+}
+func metricsRows(header []string, metrics map[string]*mergeRequestMetrics) [][]string {
+	names := make([]string, 0)
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if metrics[names[i]].Merged == metrics[names[j]].Merged {
+			return names[i] < names[j]
+		}
+		return metrics[names[i]].Merged > metrics[names[j]].Merged
+	})
+	rows := [][]string{header}
+	for _, name := range names {
+		rows = append(rows, metrics[name].row(name))
+	}
+	return rows
+}
+func formatMedianAge(seconds []float64) string {
+	if len(seconds) == 0 {
+		return "-"
+	}
+	return formatAge(time.Duration(percentile(seconds, 50)) * time.Second)
+}
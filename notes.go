@@ -0,0 +1,69 @@
+package gubot_gitlab
+
+import (
+	"fmt"
+	"github.com/ArthurHlt/gubot/robot"
+	"github.com/urfave/cli"
+	"github.com/xanzy/go-gitlab"
+	"strings"
+)
+
+type createNoteOptions struct {
+	Body string `url:"body" json:"body"`
+}
+
+func (g GitlabApp) cmdComment(envelop robot.Envelop, c *cli.Context) error {
+	if len(c.Args()) < 2 {
+		fmt.Fprint(c.App.Writer, "I need a reference and a text to comment, e.g.: gitlab comment group/project!12 \"looks good\"")
+		return nil
+	}
+	ref, err := g.resolveRef(c.Args().First(), "")
+	if err != nil {
+		fmt.Fprint(c.App.Writer, err.Error())
+		return nil
+	}
+	options, err := g.actionOptions(*ref, envelop.User.Name, gitlab.GuestPermissions)
+	if err != nil {
+		fmt.Fprint(c.App.Writer, err.Error())
+		return nil
+	}
+	body := strings.Join(c.Args().Tail(), " ")
+	if !g.conf.GitlabActAsUser {
+		body += fmt.Sprintf("\n\n_Posted from chat by @%s._", g.retrieveGitlabUserFromChat(envelop.User.Name))
+	}
+	err = g.createNote(*ref, body, options...)
+	if err != nil {
+		fmt.Fprintf(c.App.Writer, "Sorry I can't comment on %s: %s", ref, err.Error())
+		return nil
+	}
+	fmt.Fprintf(c.App.Writer, "Your comment has been posted on %s.", ref)
+	return nil
+}
+
+// noteClaim leaves a note on the merge request or the issue claimed in chat
+// when GitlabNoteOnClaim is set.
+func (g GitlabApp) noteClaim(notif GitlabNotification, username string) {
+	if !g.conf.GitlabNoteOnClaim {
+		return
+	}
+	err := g.createNote(*refFromNotif(&notif), fmt.Sprintf(
+		"@%s picked this up from %s.",
+		g.retrieveGitlabUserFromChat(username),
+		notif.ChannelName,
+	))
+	if err != nil {
+		robot.Logger().Error("Error when leaving a note on %s: %s", notif.WebUrl, err.Error())
+	}
+}
+func (g GitlabApp) createNote(ref gitlabRef, body string, options ...gitlab.OptionFunc) error {
+	opt := &createNoteOptions{
+		Body: body,
+	}
+	var err error
+	if ref.Type == MERGE_REQUEST_EVENT_NAME {
+		_, err = g.DoMergeRequestRequest(ref.pid(), ref.ObjectIid, "POST", "notes", opt, nil, options...)
+	} else {
+		_, err = g.DoIssueRequest(ref.pid(), ref.ObjectIid, "POST", "notes", opt, nil, options...)
+	}
+	return err
+}
@@ -0,0 +1,231 @@
+package gubot_gitlab
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ArthurHlt/gubot/robot"
+	"github.com/urfave/cli"
+	"github.com/xanzy/go-gitlab"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	PIPELINE_ACTION_RETRY = "retry"
+	PIPELINE_ACTION_CANCEL = "cancel"
+)
+
+type listProjectPipelinesOptions struct {
+	gitlab.ListOptions
+	Ref string `url:"ref,omitempty" json:"ref,omitempty"`
+}
+type pipelineVariable struct {
+	Key   string `url:"key" json:"key"`
+	Value string `url:"value" json:"value"`
+}
+type createPipelineOptions struct {
+	Ref       string             `url:"ref" json:"ref"`
+	Variables []pipelineVariable `url:"variables,omitempty" json:"variables,omitempty"`
+}
+
+func pipelineRetryCommand(projectPath string, pipelineId int) string {
+	return fmt.Sprintf("gitlab pipeline retry %s %d", projectPath, pipelineId)
+}
+func jobRetryCommand(jobId int) string {
+	return fmt.Sprintf("gitlab job retry %d", jobId)
+}
+
+// pipelineActionLevel returns the access level required to retry, cancel or
+// run pipelines, without GitlabActAsUser they run with the robot token and
+// gitlab can't enforce protected refs for the chat user, master permissions
+// are then required.
+func (g GitlabApp) pipelineActionLevel() gitlab.AccessLevelValue {
+	if g.conf.GitlabActAsUser {
+		return gitlab.DeveloperPermissions
+	}
+	return gitlab.MasterPermissions
+}
+func (g GitlabApp) cmdPipelineAction(envelop robot.Envelop, c *cli.Context, action string) error {
+	projectPath := c.Args().First()
+	if projectPath == "" {
+		fmt.Fprintf(c.App.Writer, "I need a project to %s a pipeline, e.g.: gitlab pipeline %s group/project [pipeline-id|ref]", action, action)
+		return nil
+	}
+	options, err := g.actionOptions(gitlabRef{ProjectPath: projectPath}, envelop.User.Name, g.pipelineActionLevel())
+	if err != nil {
+		fmt.Fprint(c.App.Writer, err.Error())
+		return nil
+	}
+	pipeline, err := g.findPipeline(projectPath, c.Args().Get(1))
+	if err != nil {
+		fmt.Fprint(c.App.Writer, err.Error())
+		return nil
+	}
+	var result pipelineInfo
+	_, err = g.DoPipelineRequest(projectPath, pipeline.ID, "POST", action, nil, &result, options...)
+	if err != nil {
+		fmt.Fprintf(c.App.Writer, "Sorry I can't %s pipeline #%d: %s", action, pipeline.ID, err.Error())
+		return nil
+	}
+	fmt.Fprintf(c.App.Writer, "Pipeline [#%d](%s) on %s is now %s.", result.ID, result.WebURL, result.Ref, result.Status)
+	return nil
+}
+func (g GitlabApp) cmdPipelineRun(envelop robot.Envelop, c *cli.Context) error {
+	if len(c.Args()) < 2 {
+		fmt.Fprint(c.App.Writer, "I need a project and a ref to run a pipeline, e.g.: gitlab pipeline run group/project master [KEY=VALUE...]")
+		return nil
+	}
+	projectPath := c.Args().First()
+	opt := &createPipelineOptions{
+		Ref: c.Args().Get(1),
+		Variables: make([]pipelineVariable, 0),
+	}
+	for _, variable := range c.Args()[2:] {
+		keyValue := strings.SplitN(variable, "=", 2)
+		if len(keyValue) != 2 || keyValue[0] == "" {
+			fmt.Fprintf(c.App.Writer, "Variable '%s' must be in format KEY=VALUE.", variable)
+			return nil
+		}
+		opt.Variables = append(opt.Variables, pipelineVariable{
+			Key: keyValue[0],
+			Value: keyValue[1],
+		})
+	}
+	options, err := g.actionOptions(gitlabRef{ProjectPath: projectPath}, envelop.User.Name, g.pipelineActionLevel())
+	if err != nil {
+		fmt.Fprint(c.App.Writer, err.Error())
+		return nil
+	}
+	project, err := parseID(projectPath)
+	if err != nil {
+		fmt.Fprint(c.App.Writer, err.Error())
+		return nil
+	}
+	req, err := g.client.NewRequest("POST", fmt.Sprintf("projects/%s/pipeline", url.QueryEscape(project)), opt, options)
+	if err != nil {
+		fmt.Fprint(c.App.Writer, err.Error())
+		return nil
+	}
+	var pipeline pipelineInfo
+	_, err = g.client.Do(req, &pipeline)
+	if err != nil {
+		fmt.Fprintf(c.App.Writer, "Sorry I can't run a pipeline on %s: %s", opt.Ref, err.Error())
+		return nil
+	}
+	fmt.Fprintf(c.App.Writer, "Pipeline [#%d](%s) has been started on %s.", pipeline.ID, pipeline.WebURL, pipeline.Ref)
+	return nil
+}
+func (g GitlabApp) cmdJobRetry(envelop robot.Envelop, c *cli.Context) error {
+	project, jobId, err := g.jobFromArgs(c.Args())
+	if err != nil {
+		fmt.Fprint(c.App.Writer, err.Error())
+		return nil
+	}
+	options, err := g.actionOptions(project, envelop.User.Name, g.pipelineActionLevel())
+	if err != nil {
+		fmt.Fprint(c.App.Writer, err.Error())
+		return nil
+	}
+	var job struct {
+		ID     int    `json:"id"`
+		Name   string `json:"name"`
+		Status string `json:"status"`
+	}
+	_, err = g.DoJobRequest(project.pid(), jobId, "POST", "retry", nil, &job, options...)
+	if err != nil {
+		fmt.Fprintf(c.App.Writer, "Sorry I can't retry job %d: %s", jobId, err.Error())
+		return nil
+	}
+	fmt.Fprintf(c.App.Writer, "Job %s has been retried as job %d, it's now %s.", job.Name, job.ID, job.Status)
+	return nil
+}
+
+// jobFromArgs returns the project and the id of a job given as <job-id> or
+// <project> <job-id>, the project of a job id alone is found in the jobs
+// received by webhook.
+func (g GitlabApp) jobFromArgs(args []string) (gitlabRef, int, error) {
+	usage := errors.New("I need a job id to retry a job, e.g.: gitlab job retry [group/project] 1234")
+	if len(args) == 0 {
+		return gitlabRef{}, 0, usage
+	}
+	jobId, err := strconv.Atoi(args[len(args) - 1])
+	if err != nil {
+		return gitlabRef{}, 0, usage
+	}
+	if len(args) > 1 {
+		return gitlabRef{ProjectPath: args[0]}, jobId, nil
+	}
+	var job GitlabJob
+	robot.Store().Where(&GitlabJob{
+		JobID: jobId,
+	}).First(&job)
+	if job.ID == 0 {
+		return gitlabRef{}, 0, fmt.Errorf("I don't know job %d, give me its project too, e.g.: gitlab job retry group/project %d", jobId, jobId)
+	}
+	return gitlabRef{ProjectID: job.ProjectID, ProjectPath: job.ProjectPath}, jobId, nil
+}
+
+// findPipeline retrieves a pipeline of a project by its id or the last one
+// on a ref, without id nor ref it's the last pipeline of the project.
+func (g GitlabApp) findPipeline(projectPath, idOrRef string) (*pipelineInfo, error) {
+	project, err := parseID(projectPath)
+	if err != nil {
+		return nil, err
+	}
+	if pipelineId, err := strconv.Atoi(idOrRef); err == nil {
+		var pipeline pipelineInfo
+		_, err = g.DoPipelineRequest(projectPath, pipelineId, "GET", "", nil, &pipeline)
+		if err != nil {
+			return nil, err
+		}
+		return &pipeline, nil
+	}
+	req, err := g.client.NewRequest("GET", fmt.Sprintf("projects/%s/pipelines", url.QueryEscape(project)), &listProjectPipelinesOptions{
+		ListOptions: gitlab.ListOptions{PerPage: 1},
+		Ref: idOrRef,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	var pipelines []*pipelineInfo
+	_, err = g.client.Do(req, &pipelines)
+	if err != nil {
+		return nil, err
+	}
+	if len(pipelines) == 0 {
+		return nil, errors.New("I can't found any pipeline.")
+	}
+	return pipelines[0], nil
+}
+
+// DoPipelineRequest calls an endpoint of a pipeline, action is the path after
+// the pipeline (e.g.: retry) and can be empty. The response is decoded in v
+// when it's not nil.
+func (g GitlabApp) DoPipelineRequest(pid interface{}, pipelineId int, method, action string, opt interface{}, v interface{}, options ...gitlab.OptionFunc) (*gitlab.Response, error) {
+	return g.doProjectRequest(pid, fmt.Sprintf("pipelines/%d", pipelineId), method, action, opt, v, options...)
+}
+
+// DoJobRequest calls an endpoint of a job, action is the path after the job
+// (e.g.: retry) and can be empty. The response is decoded in v when it's not
+// nil.
+func (g GitlabApp) DoJobRequest(pid interface{}, jobId int, method, action string, opt interface{}, v interface{}, options ...gitlab.OptionFunc) (*gitlab.Response, error) {
+	return g.doProjectRequest(pid, fmt.Sprintf("jobs/%d", jobId), method, action, opt, v, options...)
+}
+func (g GitlabApp) doProjectRequest(pid interface{}, resource string, method, action string, opt interface{}, v interface{}, options ...gitlab.OptionFunc) (*gitlab.Response, error) {
+	project, err := parseID(pid)
+	if err != nil {
+		return nil, err
+	}
+	u := fmt.Sprintf("projects/%s/%s", url.QueryEscape(project), resource)
+	if action != "" {
+		u += "/" + action
+	}
+
+	req, err := g.client.NewRequest(method, u, opt, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return g.client.Do(req, v)
+}
@@ -0,0 +1,455 @@
+package gubot_gitlab
+
+import (
+	"fmt"
+	"github.com/ArthurHlt/gubot/robot"
+	"github.com/jinzhu/gorm"
+	"github.com/urfave/cli"
+	"github.com/xanzy/go-gitlab"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	PIPELINE_STATS_DAYS = 30
+	PIPELINE_STATS_SLOWEST_JOBS = 5
+	// PIPELINE_STATS_BACKFILL_LIMIT is the maximum number of pipelines
+	// retrieved from gitlab when they were not received by webhook, it's
+	// done while the user waits
+	PIPELINE_STATS_BACKFILL_LIMIT = 50
+)
+
+var sparklineChars = []rune("▁▂▃▄▅▆▇█")
+
+type backfillPipelinesOptions struct {
+	gitlab.ListOptions
+	Ref          string     `url:"ref,omitempty" json:"ref,omitempty"`
+	UpdatedAfter *time.Time `url:"updated_after,omitempty" json:"updated_after,omitempty"`
+}
+type pipelineDetails struct {
+	ID             int        `json:"id"`
+	Status         string     `json:"status"`
+	Ref            string     `json:"ref"`
+	Sha            string     `json:"sha"`
+	WebURL         string     `json:"web_url"`
+	CreatedAt      *time.Time `json:"created_at"`
+	Duration       int        `json:"duration"`
+	QueuedDuration float64    `json:"queued_duration"`
+	User           struct {
+		Username string `json:"username"`
+	} `json:"user"`
+}
+type pipelineJob struct {
+	ID           int     `json:"id"`
+	Name         string  `json:"name"`
+	Stage        string  `json:"stage"`
+	Status       string  `json:"status"`
+	Duration     float64 `json:"duration"`
+	AllowFailure bool    `json:"allow_failure"`
+}
+type jobDuration struct {
+	Name   string
+	Median float64
+	Max    float64
+	Runs   int
+}
+
+func (g GitlabApp) cmdPipelineStats(envelop robot.Envelop, c *cli.Context) error {
+	args, flags := parseFlags(c.Args(), "ref", "days")
+	if len(args) == 0 {
+		fmt.Fprint(c.App.Writer, "I need a project to show pipeline statistics, e.g.: gitlab pipeline stats group/project [--ref master] [--days 30]")
+		return nil
+	}
+	projectPath := args[0]
+	days := PIPELINE_STATS_DAYS
+	if flags["days"] != "" {
+		var err error
+		days, err = strconv.Atoi(flags["days"])
+		if err != nil || days <= 0 {
+			fmt.Fprint(c.App.Writer, "You gave me an incorrect number of days.")
+			return nil
+		}
+	}
+	_, err := g.actionOptions(gitlabRef{ProjectPath: projectPath}, envelop.User.Name, gitlab.ReporterPermissions)
+	if err != nil {
+		fmt.Fprint(c.App.Writer, err.Error())
+		return nil
+	}
+	project, _, err := g.client.Projects.GetProject(projectPath)
+	if err != nil {
+		fmt.Fprintf(c.App.Writer, "Sorry I can't found project %s: %s", projectPath, err.Error())
+		return nil
+	}
+	since := time.Now().AddDate(0, 0, -days)
+	err = g.backfillPipelines(envelop, project, flags["ref"], since)
+	if err != nil {
+		robot.Logger().Error("Error when retrieving pipelines of %s from gitlab: %s", project.PathWithNamespace, err.Error())
+	}
+	fmt.Fprint(c.App.Writer, pipelineStats(project.PathWithNamespace, project.ID, flags["ref"], since, days))
+	return nil
+}
+
+// backfillPipelines stores pipelines and jobs from gitlab which were not
+// received by webhook, e.g. before the robot was installed or when the
+// webhook doesn't send jobs.
+func (g GitlabApp) backfillPipelines(envelop robot.Envelop, project *gitlab.Project, ref string, since time.Time) error {
+	projectPath := project.PathWithNamespace
+	u := fmt.Sprintf("projects/%s/pipelines", url.QueryEscape(projectPath))
+	opt := &backfillPipelinesOptions{
+		ListOptions: gitlab.ListOptions{
+			Page: 1,
+			PerPage: 100,
+		},
+		Ref: ref,
+		UpdatedAfter: &since,
+	}
+	pipelines := make([]*pipelineDetails, 0)
+	for len(pipelines) < PIPELINE_STATS_BACKFILL_LIMIT {
+		req, err := g.client.NewRequest("GET", u, opt, nil)
+		if err != nil {
+			return err
+		}
+		var page []*pipelineDetails
+		resp, err := g.client.Do(req, &page)
+		if err != nil {
+			return err
+		}
+		pipelines = append(pipelines, page...)
+		if resp == nil || resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	if len(pipelines) > PIPELINE_STATS_BACKFILL_LIMIT {
+		pipelines = pipelines[:PIPELINE_STATS_BACKFILL_LIMIT]
+	}
+	missingPipelines := make(map[int]bool)
+	missingJobs := make([]*pipelineDetails, 0)
+	for _, pipeline := range pipelines {
+		var count int
+		robot.Store().Model(&GitlabPipeline{}).Where(map[string]interface{}{
+			"pipeline_id": pipeline.ID,
+			"project_path": projectPath,
+		}).Count(&count)
+		missingPipelines[pipeline.ID] = count == 0
+		robot.Store().Model(&GitlabJob{}).Where(map[string]interface{}{
+			"pipeline_id": pipeline.ID,
+			"project_id": project.ID,
+		}).Count(&count)
+		if missingPipelines[pipeline.ID] || count == 0 {
+			missingJobs = append(missingJobs, pipeline)
+		}
+	}
+	if len(missingJobs) == 0 {
+		return nil
+	}
+	robot.SendMessages(envelop, fmt.Sprintf("Retrieving %d pipeline(s) of %s from gitlab, it can take a while...", len(missingJobs), projectPath))
+	for _, pipeline := range missingJobs {
+		createdAt := time.Now()
+		if pipeline.CreatedAt != nil {
+			createdAt = *pipeline.CreatedAt
+		}
+		if !missingPipelines[pipeline.ID] {
+			err := g.backfillJobs(project, pipeline.ID, pipeline.Ref, pipeline.Sha, createdAt)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		var details pipelineDetails
+		_, err := g.DoPipelineRequest(projectPath, pipeline.ID, "GET", "", nil, &details)
+		if err != nil {
+			return err
+		}
+		stored := &GitlabPipeline{
+			PipelineID: details.ID,
+			ProjectPath: projectPath,
+			ProjectUrl: project.WebURL,
+			Ref: details.Ref,
+			Sha: details.Sha,
+			Status: details.Status,
+			Username: details.User.Username,
+			DefaultBranch: details.Ref == project.DefaultBranch,
+			Duration: details.Duration,
+			QueuedDuration: int(details.QueuedDuration),
+			WebUrl: details.WebURL,
+		}
+		if details.CreatedAt != nil {
+			stored.CreatedAt = *details.CreatedAt
+		}
+		robot.Store().Create(stored)
+		err = g.backfillJobs(project, details.ID, details.Ref, details.Sha, stored.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillJobs stores jobs of a pipeline which were not received by webhook.
+func (g GitlabApp) backfillJobs(project *gitlab.Project, pipelineID int, ref, sha string, createdAt time.Time) error {
+	jobs := make([]*pipelineJob, 0)
+	u := fmt.Sprintf("projects/%s/pipelines/%d/jobs", url.QueryEscape(project.PathWithNamespace), pipelineID)
+	err := g.listAllPages(u, func(req *http.Request) (*gitlab.Response, error) {
+		var pageJobs []*pipelineJob
+		resp, err := g.client.Do(req, &pageJobs)
+		jobs = append(jobs, pageJobs...)
+		return resp, err
+	})
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		var count int
+		robot.Store().Model(&GitlabJob{}).Where(map[string]interface{}{
+			"job_id": job.ID,
+			"project_id": project.ID,
+		}).Count(&count)
+		if count > 0 {
+			continue
+		}
+		robot.Store().Create(&GitlabJob{
+			Model: gorm.Model{CreatedAt: createdAt},
+			JobID: job.ID,
+			PipelineID: pipelineID,
+			ProjectID: project.ID,
+			ProjectPath: project.PathWithNamespace,
+			Name: job.Name,
+			Stage: job.Stage,
+			Ref: ref,
+			Sha: sha,
+			Status: job.Status,
+			AllowFailure: job.AllowFailure,
+			Duration: job.Duration,
+		})
+	}
+	return nil
+}
+
+// pipelineStats computes statistics from stored pipelines and jobs.
+func pipelineStats(projectPath string, projectID int, ref string, since time.Time, days int) string {
+	var pipelines []GitlabPipeline
+	robot.Store().Where(&GitlabPipeline{
+		ProjectPath: projectPath,
+		Ref: ref,
+	}).Where("created_at > ?", since).Order("created_at asc").Find(&pipelines)
+	title := fmt.Sprintf("**Pipelines of %s", projectPath)
+	if ref != "" {
+		title += " on " + ref
+	}
+	title += fmt.Sprintf(" during the last %d days**\n", days)
+	if len(pipelines) == 0 {
+		return title + "No pipelines found."
+	}
+
+	durations := make([]float64, 0)
+	queuedDurations := make([]float64, 0)
+	dailyRuns := make([]float64, days)
+	dailyFailures := make([]float64, days)
+	dailyDurations := make([][]float64, days)
+	succeeded, failed := 0, 0
+	for _, pipeline := range pipelines {
+		day := int(pipeline.CreatedAt.Sub(since).Hours() / 24)
+		if day < 0 || day >= days {
+			continue
+		}
+		switch pipeline.Status {
+		case "success":
+			succeeded++
+		case "failed":
+			failed++
+			dailyFailures[day]++
+		default:
+			continue
+		}
+		dailyRuns[day]++
+		if pipeline.Duration > 0 {
+			durations = append(durations, float64(pipeline.Duration))
+			dailyDurations[day] = append(dailyDurations[day], float64(pipeline.Duration))
+		}
+		if pipeline.QueuedDuration > 0 {
+			queuedDurations = append(queuedDurations, float64(pipeline.QueuedDuration))
+		}
+	}
+	finished := succeeded + failed
+	if finished == 0 {
+		return title + fmt.Sprintf("%d pipeline(s) found but none finished.", len(pipelines))
+	}
+
+	dailyFailureRates := make([]float64, days)
+	dailyMedians := make([]float64, days)
+	for day := 0; day < days; day++ {
+		if dailyRuns[day] > 0 {
+			dailyFailureRates[day] = dailyFailures[day] / dailyRuns[day]
+		}
+		dailyMedians[day] = percentile(dailyDurations[day], 50)
+	}
+
+	rows := [][]string{
+		{"Finished", strconv.Itoa(finished), ""},
+		{"Success rate", fmt.Sprintf("%d%%", succeeded * 100 / finished), ""},
+		{"Failures", strconv.Itoa(failed), sparkline(dailyFailures)},
+		{"Failure rate", "", sparkline(dailyFailureRates)},
+		{"Median duration", formatSeconds(percentile(durations, 50)), sparkline(dailyMedians)},
+		{"P95 duration", formatSeconds(percentile(durations, 95)), ""},
+	}
+	if len(queuedDurations) > 0 {
+		rows = append(rows,
+			[]string{"Median queue time", formatSeconds(percentile(queuedDurations, 50)), ""},
+			[]string{"P95 queue time", formatSeconds(percentile(queuedDurations, 95)), ""},
+		)
+	}
+	message := title + "```\n" + formatTable(rows) + "```\n"
+	message += fmt.Sprintf("Failure trend: %s\n", failureTrend(dailyFailures, dailyRuns))
+
+	jobs := slowestJobs(projectID, ref, since)
+	if len(jobs) > 0 {
+		jobRows := [][]string{{"Job", "Median", "Max", "Runs"}}
+		for _, job := range jobs {
+			jobRows = append(jobRows, []string{
+				job.Name,
+				formatSeconds(job.Median),
+				formatSeconds(job.Max),
+				strconv.Itoa(job.Runs),
+			})
+		}
+		message += "\n**Slowest jobs:**\n```\n" + formatTable(jobRows) + "```"
+	}
+	return message
+}
+
+// slowestJobs ranks jobs by their median duration, jobs of every refs are
+// taken when ref is empty.
+func slowestJobs(projectID int, ref string, since time.Time) []*jobDuration {
+	db := robot.Store().Where("project_id = ? AND created_at > ? AND duration > 0", projectID, since)
+	if ref != "" {
+		db = db.Where("ref = ?", ref)
+	}
+	var jobs []GitlabJob
+	db.Find(&jobs)
+	durationsByName := make(map[string][]float64)
+	for _, job := range jobs {
+		durationsByName[job.Name] = append(durationsByName[job.Name], job.Duration)
+	}
+	durations := make([]*jobDuration, 0)
+	for name, jobDurations := range durationsByName {
+		durations = append(durations, &jobDuration{
+			Name: name,
+			Median: percentile(jobDurations, 50),
+			Max: percentile(jobDurations, 100),
+			Runs: len(jobDurations),
+		})
+	}
+	sort.Slice(durations, func(i, j int) bool {
+		return durations[i].Median > durations[j].Median
+	})
+	if len(durations) > PIPELINE_STATS_SLOWEST_JOBS {
+		durations = durations[:PIPELINE_STATS_SLOWEST_JOBS]
+	}
+	return durations
+}
+
+// failureTrend compares the failure rate of the second half of the period
+// with the first half.
+func failureTrend(dailyFailures, dailyRuns []float64) string {
+	half := len(dailyRuns) / 2
+	rate := func(from, to int) (float64, bool) {
+		failures, runs := 0.0, 0.0
+		for day := from; day < to; day++ {
+			failures += dailyFailures[day]
+			runs += dailyRuns[day]
+		}
+		if runs == 0 {
+			return 0, false
+		}
+		return failures / runs, true
+	}
+	before, okBefore := rate(0, half)
+	after, okAfter := rate(half, len(dailyRuns))
+	if !okBefore || !okAfter {
+		return "not enough data"
+	}
+	diff := int(math.Round((after - before) * 100))
+	switch {
+	case diff > 0:
+		return fmt.Sprintf("worse, failure rate went from %d%% to %d%%", int(before * 100), int(after * 100))
+	case diff < 0:
+		return fmt.Sprintf("better, failure rate went from %d%% to %d%%", int(before * 100), int(after * 100))
+	}
+	return fmt.Sprintf("stable at %d%% of failures", int(after * 100))
+}
+
+// percentile returns the nearest rank percentile of values, 0 when there is
+// no value.
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank - 1]
+}
+
+// sparkline draws values with block characters from the lowest to the
+// highest value.
+func sparkline(values []float64) string {
+	max := 0.0
+	for _, value := range values {
+		max = math.Max(max, value)
+	}
+	line := make([]rune, len(values))
+	for i, value := range values {
+		index := 0
+		if max > 0 {
+			index = int(value / max * float64(len(sparklineChars) - 1))
+		}
+		line[i] = sparklineChars[index]
+	}
+	return string(line)
+}
+
+// formatTable aligns rows in columns for a code block.
+func formatTable(rows [][]string) string {
+	widths := make([]int, 0)
+	for _, row := range rows {
+		for i, cell := range row {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if size := len([]rune(cell)); size > widths[i] {
+				widths[i] = size
+			}
+		}
+	}
+	table := ""
+	for _, row := range rows {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = cell + strings.Repeat(" ", widths[i] - len([]rune(cell)))
+		}
+		table += strings.TrimRight(strings.Join(cells, "  "), " ") + "\n"
+	}
+	return table
+}
+
+// formatSeconds returns a short duration, e.g.: 1h2m, 3m12s or 45s
+func formatSeconds(seconds float64) string {
+	duration := time.Duration(seconds) * time.Second
+	if duration >= time.Hour {
+		return fmt.Sprintf("%dh%dm", int(duration.Hours()), int(duration.Minutes()) % 60)
+	}
+	if duration >= time.Minute {
+		return fmt.Sprintf("%dm%ds", int(duration.Minutes()), int(duration.Seconds()) % 60)
+	}
+	return fmt.Sprintf("%ds", int(duration.Seconds()))
+}
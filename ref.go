@@ -0,0 +1,163 @@
+package gubot_gitlab
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ArthurHlt/gubot/robot"
+	"github.com/xanzy/go-gitlab"
+	"strconv"
+	"strings"
+)
+
+// notifFromRef retrieves a notification in queue from a reference given in
+// chat, it can be the id shown in list (12 or #12) or a gitlab reference like
+// group/project!12 for a merge request or group/project#12 for an issue.
+func (g GitlabApp) notifFromRef(ref string) (*GitlabNotification, error) {
+	if ref == "" {
+		return nil, errors.New("I need the id retrieve from list or a gitlab reference (e.g.: group/project!12).")
+	}
+	where := &GitlabNotification{}
+	if index := strings.LastIndexAny(ref, "!#"); index > 0 {
+		iid, err := strconv.Atoi(ref[index + 1:])
+		if err != nil {
+			return nil, errors.New("You gave me an incorrect reference.")
+		}
+		where.ProjectPath = ref[:index]
+		where.ObjectIid = iid
+		where.Type = ISSUE_EVENT_NAME
+		if ref[index] == '!' {
+			where.Type = MERGE_REQUEST_EVENT_NAME
+		}
+	} else {
+		notifId, err := strconv.Atoi(strings.TrimPrefix(ref, "#"))
+		if err != nil {
+			return nil, errors.New("You gave me an incorrect id.")
+		}
+		where.ID = uint(notifId)
+	}
+	var notif GitlabNotification
+	robot.Store().Where(where).First(&notif)
+	if notif.ID == 0 {
+		return nil, errors.New("I can't found " + ref + " in queue.")
+	}
+	return &notif, nil
+}
+
+// gitlabRef is a merge request or an issue resolved from a chat reference,
+// Notif is set when it's in queue.
+type gitlabRef struct {
+	Type        string
+	ProjectID   int
+	ProjectPath string
+	ObjectId    int
+	ObjectIid   int
+	WebUrl      string
+	Notif       *GitlabNotification
+}
+
+func (r gitlabRef) pid() interface{} {
+	if r.ProjectID != 0 {
+		return r.ProjectID
+	}
+	return r.ProjectPath
+}
+func (r gitlabRef) String() string {
+	if r.Type == MERGE_REQUEST_EVENT_NAME {
+		return fmt.Sprintf("%s!%d", r.ProjectPath, r.ObjectIid)
+	}
+	return fmt.Sprintf("%s#%d", r.ProjectPath, r.ObjectIid)
+}
+
+// project returns a notification targeting the project of the reference to
+// resolve its members.
+func (r gitlabRef) project() GitlabNotification {
+	return GitlabNotification{
+		ProjectID: r.ProjectID,
+		ProjectPath: r.ProjectPath,
+		GroupName: namespaceFromPath(r.ProjectPath),
+	}
+}
+
+func refFromNotif(notif *GitlabNotification) *gitlabRef {
+	return &gitlabRef{
+		Type: notif.Type,
+		ProjectID: notif.ProjectID,
+		ProjectPath: notif.ProjectPath,
+		ObjectId: notif.ObjectId,
+		ObjectIid: notif.ObjectIid,
+		WebUrl: notif.WebUrl,
+		Notif: notif,
+	}
+}
+
+// resolveRef resolves a reference of the given type from queue or from
+// gitlab when it's a gitlab reference not in queue, an empty type accepts
+// merge requests and issues.
+func (g GitlabApp) resolveRef(ref string, refType string) (*gitlabRef, error) {
+	notif, err := g.notifFromRef(ref)
+	if err == nil {
+		if refType != "" && notif.Type != refType {
+			return nil, fmt.Errorf("%s is not a %s.", ref, strings.Replace(refType, "_", " ", -1))
+		}
+		// notifications queued by older versions don't have the gitlab iid
+		if notif.ObjectIid == 0 {
+			return nil, fmt.Errorf(
+				"%s with id %d was queued without its gitlab reference, use its gitlab reference instead (e.g.: group/project!12).",
+				strings.Replace(notif.Type, "_", " ", -1),
+				notif.ID,
+			)
+		}
+		return refFromNotif(notif), nil
+	}
+	index := strings.LastIndexAny(ref, "!#")
+	if index <= 0 {
+		return nil, err
+	}
+	if refType == "" {
+		refType = ISSUE_EVENT_NAME
+		if ref[index] == '!' {
+			refType = MERGE_REQUEST_EVENT_NAME
+		}
+	}
+	iid, convErr := strconv.Atoi(ref[index + 1:])
+	if convErr != nil {
+		return nil, err
+	}
+	projectPath := ref[:index]
+	if refType == MERGE_REQUEST_EVENT_NAME {
+		mrs, _, err := g.client.MergeRequests.GetMergeRequests(projectPath, &gitlab.ListMergeRequestsOptions{
+			IID: &iid,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(mrs) == 0 {
+			return nil, errors.New("I can't found merge request " + ref + ".")
+		}
+		return &gitlabRef{
+			Type: refType,
+			ProjectID: mrs[0].ProjectID,
+			ProjectPath: projectPath,
+			ObjectId: mrs[0].ID,
+			ObjectIid: mrs[0].IID,
+			WebUrl: mrs[0].WebURL,
+		}, nil
+	}
+	issues, _, err := g.client.Issues.ListProjectIssues(projectPath, &gitlab.ListProjectIssuesOptions{
+		IID: &iid,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(issues) == 0 {
+		return nil, errors.New("I can't found issue " + ref + ".")
+	}
+	return &gitlabRef{
+		Type: refType,
+		ProjectID: issues[0].ProjectID,
+		ProjectPath: projectPath,
+		ObjectId: issues[0].ID,
+		ObjectIid: issues[0].IID,
+		WebUrl: issues[0].WebURL,
+	}, nil
+}
@@ -0,0 +1,282 @@
+package gubot_gitlab
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ArthurHlt/gubot/robot"
+	"regexp"
+	"strings"
+)
+
+const (
+	RENDERER_MARKDOWN = "markdown"
+	RENDERER_SLACK = "slack"
+	RENDERER_MATTERMOST = "mattermost"
+
+	COLOR_INFO = "#1f78d1"
+	COLOR_WARNING = "#fc9403"
+	COLOR_FAILED = "#db3b21"
+	COLOR_SUCCESS = "#1aaa55"
+)
+
+var markdownLinkRegex = regexp.MustCompile(`\[([^\]]*)\]\(([^)]*)\)`)
+
+type ChatField struct {
+	Title string
+	Value string
+	Short bool
+}
+type ChatAction struct {
+	Text string
+	Url  string
+}
+
+// ChatCard is a structured message for chat systems supporting attachments,
+// chat systems without this support only receive the markdown message.
+type ChatCard struct {
+	Color    string
+	Title    string
+	TitleUrl string
+	Fields   []ChatField
+	Actions  []ChatAction
+}
+type chatRenderer interface {
+	Send(channelName, mentions, message string, card *ChatCard) error
+}
+
+type markdownRenderer struct{}
+
+func (r markdownRenderer) Send(channelName, mentions, message string, card *ChatCard) error {
+	if mentions != "" {
+		message = mentions + " : " + message
+	}
+	robot.SendMessages(robot.Envelop{
+		ChannelName: channelName,
+	}, message)
+	return nil
+}
+
+// attachmentRenderer sends cards as attachments through an incoming webhook,
+// slack and mattermost share the same format except for buttons which are
+// only available on slack, mattermost gets links.
+type attachmentRenderer struct {
+	webhookUrl string
+	slack      bool
+}
+type attachmentField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+type attachmentAction struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+	Url  string `json:"url"`
+}
+type attachment struct {
+	Fallback  string             `json:"fallback"`
+	Color     string             `json:"color,omitempty"`
+	Title     string             `json:"title,omitempty"`
+	TitleLink string             `json:"title_link,omitempty"`
+	Text      string             `json:"text,omitempty"`
+	Fields    []attachmentField  `json:"fields,omitempty"`
+	Actions   []attachmentAction `json:"actions,omitempty"`
+}
+type attachmentPayload struct {
+	Channel     string       `json:"channel"`
+	Text        string       `json:"text,omitempty"`
+	Attachments []attachment `json:"attachments,omitempty"`
+}
+
+func (r attachmentRenderer) Send(channelName, mentions, message string, card *ChatCard) error {
+	if card == nil {
+		return markdownRenderer{}.Send(channelName, mentions, message, card)
+	}
+	body, err := json.Marshal(attachmentPayload{
+		Channel: channelName,
+		Text: mentions,
+		Attachments: []attachment{newAttachment(message, card, r.slack)},
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := robot.HttpClient().Post(r.webhookUrl, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("chat webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+func newAttachment(message string, card *ChatCard, slack bool) attachment {
+	att := attachment{
+		Fallback: message,
+		Color: card.Color,
+		Title: card.Title,
+		TitleLink: card.TitleUrl,
+		Text: formatForChat(message, slack),
+	}
+	for _, field := range card.Fields {
+		att.Fields = append(att.Fields, attachmentField{
+			Title: field.Title,
+			Value: formatForChat(field.Value, slack),
+			Short: field.Short,
+		})
+	}
+	links := make([]string, 0)
+	for _, action := range card.Actions {
+		if slack {
+			att.Actions = append(att.Actions, attachmentAction{
+				Type: "button",
+				Text: action.Text,
+				Url: action.Url,
+			})
+			continue
+		}
+		links = append(links, fmt.Sprintf("[%s](%s)", action.Text, action.Url))
+	}
+	if len(links) > 0 {
+		att.Text += "\n" + strings.Join(links, " | ")
+	}
+	return att
+}
+
+// formatForChat converts markdown links to slack links, mattermost supports
+// markdown.
+func formatForChat(text string, slack bool) string {
+	if !slack {
+		return text
+	}
+	text = strings.Replace(text, "**", "*", -1)
+	return markdownLinkRegex.ReplaceAllString(text, "<$2|$1>")
+}
+func newRenderer(conf GitlabConfig) (chatRenderer, error) {
+	switch conf.GitlabChatRenderer {
+	case "", RENDERER_MARKDOWN:
+		return markdownRenderer{}, nil
+	case RENDERER_SLACK, RENDERER_MATTERMOST:
+		if conf.GitlabChatApiToken != "" {
+			return newApiRenderer(conf)
+		}
+		if conf.GitlabChatWebhookUrl == "" {
+			return nil, errors.New("GitlabChatWebhookUrl or GitlabChatApiToken conf parameter must be set to use renderer " + conf.GitlabChatRenderer + ".")
+		}
+		return attachmentRenderer{
+			webhookUrl: conf.GitlabChatWebhookUrl,
+			slack: conf.GitlabChatRenderer == RENDERER_SLACK,
+		}, nil
+	}
+	return nil, errors.New("Unknown renderer " + conf.GitlabChatRenderer + ".")
+}
+
+// sendMessage sends a message through the renderer and falls back on markdown
+// when the renderer fails.
+func (g GitlabApp) sendMessage(channelName, mentions, message string, card *ChatCard) {
+	err := g.renderer.Send(channelName, mentions, message, card)
+	if err == nil {
+		return
+	}
+	robot.Logger().Error("Error when sending rich message, falling back to markdown: %s", err.Error())
+	markdownRenderer{}.Send(channelName, mentions, message, nil)
+}
+
+// newCard builds the card of an event from its template data.
+func newCard(data TemplateData) *ChatCard {
+	card := &ChatCard{
+		TitleUrl: data.Url,
+		Fields: []ChatField{
+			{
+				Title: "Project",
+				Value: fmt.Sprintf("[%s](%s)", data.Project.Path, data.Project.Url),
+				Short: true,
+			},
+		},
+	}
+	if data.Author != "" {
+		card.Fields = append(card.Fields, ChatField{
+			Title: "Author",
+			Value: "@" + data.Author,
+			Short: true,
+		})
+	}
+	switch data.Event {
+	case MERGE_REQUEST_EVENT_NAME:
+		card.Color = COLOR_INFO
+		card.Title = fmt.Sprintf("Merge request !%d: %s", data.Iid, data.Title)
+		card.Fields = append(card.Fields, ChatField{
+			Title: "Branch",
+			Value: data.SourceBranch + " → " + data.TargetBranch,
+			Short: true,
+		})
+		card.Actions = append(card.Actions, ChatAction{
+			Text: "View merge request",
+			Url: data.Url,
+		})
+	case ISSUE_EVENT_NAME:
+		card.Color = COLOR_WARNING
+		card.Title = fmt.Sprintf("Issue #%d: %s", data.Iid, data.Title)
+		card.Actions = append(card.Actions, ChatAction{
+			Text: "View issue",
+			Url: data.Url,
+		})
+	case BUILD_EVENT_NAME:
+		card.Color = pipelineColor(data.Build.Status)
+		card.Title = fmt.Sprintf("Build %s %s on %s", data.Build.Name, data.Build.Status, data.Pipeline.Ref)
+		card.Fields = append(card.Fields, ChatField{
+			Title: "Stage",
+			Value: data.Build.Stage,
+			Short: true,
+		})
+		if data.Build.FlakyCount > 0 {
+			card.Fields = append(card.Fields, ChatField{
+				Title: "Flaky",
+				Value: fmt.Sprintf("failed then passed on retry %d time(s) lately", data.Build.FlakyCount),
+				Short: true,
+			})
+		}
+		if data.Build.RetryCommand != "" {
+			card.Fields = append(card.Fields, ChatField{
+				Title: "Retry",
+				Value: "`" + data.Build.RetryCommand + "`",
+			})
+		}
+		card.Actions = append(card.Actions, ChatAction{
+			Text: "View build",
+			Url: data.Build.Url,
+		})
+	case PIPELINE_EVENT_NAME:
+		card.Color = pipelineColor(data.Pipeline.Status)
+		card.Title = fmt.Sprintf("Pipeline #%d %s on %s", data.Pipeline.ID, data.Pipeline.Status, data.Pipeline.Ref)
+		if data.Pipeline.RetryCommand != "" {
+			card.Fields = append(card.Fields, ChatField{
+				Title: "Retry",
+				Value: "`" + data.Pipeline.RetryCommand + "`",
+			})
+		}
+		card.Actions = append(card.Actions, ChatAction{
+			Text: "View pipeline",
+			Url: data.Pipeline.Url,
+		})
+	}
+	if len(data.Labels) > 0 {
+		card.Fields = append(card.Fields, ChatField{
+			Title: "Labels",
+			Value: strings.Join(data.Labels, ", "),
+			Short: true,
+		})
+	}
+	return card
+}
+func pipelineColor(status string) string {
+	switch status {
+	case "success":
+		return COLOR_SUCCESS
+	case "failed":
+		return COLOR_FAILED
+	}
+	return COLOR_WARNING
+}
@@ -0,0 +1,328 @@
+package gubot_gitlab
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/ArthurHlt/gubot/robot"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DATE_FORMAT = "2006-01-02"
+
+var defaultWorkDays = []string{"mon", "tue", "wed", "thu", "fri"}
+
+// workingSchedule is the parsed form of GitlabWorkingHours, hours are stored
+// in minutes since midnight.
+type workingSchedule struct {
+	channels   []string
+	users      []string
+	location   *time.Location
+	start      int
+	end        int
+	quietStart int
+	quietEnd   int
+	workDays   map[time.Weekday]bool
+	holidays   map[string]bool
+}
+
+func newWorkingSchedule(conf GitlabWorkingHours) (*workingSchedule, error) {
+	var err error
+	schedule := &workingSchedule{
+		channels: conf.Channels,
+		users: conf.Users,
+		location: time.Local,
+		start: 0,
+		end: 24 * 60,
+		workDays: make(map[time.Weekday]bool),
+		holidays: make(map[string]bool),
+	}
+	if conf.TimeZone != "" {
+		schedule.location, err = time.LoadLocation(conf.TimeZone)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if conf.Start != "" {
+		schedule.start, err = parseHourMinute(conf.Start)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if conf.End != "" {
+		schedule.end, err = parseHourMinute(conf.End)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if conf.QuietStart != "" && conf.QuietEnd != "" {
+		schedule.quietStart, err = parseHourMinute(conf.QuietStart)
+		if err != nil {
+			return nil, err
+		}
+		schedule.quietEnd, err = parseHourMinute(conf.QuietEnd)
+		if err != nil {
+			return nil, err
+		}
+	}
+	workDays := conf.WorkDays
+	if len(workDays) == 0 {
+		workDays = defaultWorkDays
+	}
+	for _, day := range workDays {
+		weekday, err := parseWeekday(day)
+		if err != nil {
+			return nil, err
+		}
+		schedule.workDays[weekday] = true
+	}
+	for _, holidayFile := range conf.HolidayFiles {
+		holidays, err := loadIcsHolidays(holidayFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, holiday := range holidays {
+			schedule.holidays[holiday] = true
+		}
+	}
+	return schedule, nil
+}
+
+// nextWorkingTime returns t if it's in working time or the beginning of the
+// next working window otherwise.
+func (s workingSchedule) nextWorkingTime(t time.Time) time.Time {
+	t = t.In(s.location)
+	for i := 0; i <= 366; i++ {
+		day := time.Date(t.Year(), t.Month(), t.Day() + i, 0, 0, 0, 0, s.location)
+		if !s.workDays[day.Weekday()] || s.holidays[day.Format(DATE_FORMAT)] {
+			continue
+		}
+		candidate := day.Add(time.Duration(s.start) * time.Minute)
+		if i == 0 && t.After(candidate) {
+			candidate = t
+		}
+		if s.quietStart != s.quietEnd && s.inQuietHours(candidate) {
+			// quiet hours crossing midnight end the next day
+			if s.quietStart > s.quietEnd && candidate.Hour() * 60 + candidate.Minute() >= s.quietStart {
+				continue
+			}
+			candidate = day.Add(time.Duration(s.quietEnd) * time.Minute)
+		}
+		if candidate.Before(day.Add(time.Duration(s.end) * time.Minute)) {
+			return candidate
+		}
+	}
+	return t
+}
+func (s workingSchedule) isWorkingTime(t time.Time) bool {
+	return !s.nextWorkingTime(t).After(t)
+}
+func (s workingSchedule) inQuietHours(t time.Time) bool {
+	minutes := t.Hour() * 60 + t.Minute()
+	if s.quietStart > s.quietEnd {
+		return minutes >= s.quietStart || minutes < s.quietEnd
+	}
+	return minutes >= s.quietStart && minutes < s.quietEnd
+}
+func (g *GitlabApp) loadSchedules() error {
+	g.schedules = make([]*workingSchedule, 0)
+	for _, conf := range g.conf.GitlabWorkingHours {
+		schedule, err := newWorkingSchedule(conf)
+		if err != nil {
+			return err
+		}
+		g.schedules = append(g.schedules, schedule)
+	}
+	return nil
+}
+
+// scheduleFor returns the working schedule for a channel or a user, a schedule
+// without channels and users is the default one. It returns nil when every
+// time is a working time.
+func (g GitlabApp) scheduleFor(channelName, username string) *workingSchedule {
+	var defaultSchedule *workingSchedule
+	for _, schedule := range g.schedules {
+		if username != "" && inSlice(schedule.users, username) {
+			return schedule
+		}
+		if channelName != "" && inSlice(schedule.channels, channelName) {
+			return schedule
+		}
+		if defaultSchedule == nil && len(schedule.users) == 0 && len(schedule.channels) == 0 {
+			defaultSchedule = schedule
+		}
+	}
+	return defaultSchedule
+}
+func (g GitlabApp) isWorkingTime(channelName, username string) bool {
+	schedule := g.scheduleFor(channelName, username)
+	if schedule == nil {
+		return true
+	}
+	return schedule.isWorkingTime(time.Now())
+}
+
+// sendDirectOrDefer sends a direct message to a user during their working
+// time or keeps it to send it at the beginning of their next working window,
+// only the last message deferred for a notification is kept.
+func (g GitlabApp) sendDirectOrDefer(username string, message string, notifId uint) {
+	g.directOrDefer(GitlabDeferredMessage{
+		NotificationID: notifId,
+		Username: username,
+		Message: message,
+	})
+}
+
+// sendReminderOrDefer is sendDirectOrDefer for a reminder, the reminder is
+// dropped if the notification is claimed before it's sent.
+func (g GitlabApp) sendReminderOrDefer(username string, message string, notifId uint) {
+	g.directOrDefer(GitlabDeferredMessage{
+		NotificationID: notifId,
+		Username: username,
+		Message: message,
+		Reminder: true,
+	})
+}
+func (g GitlabApp) directOrDefer(deferredMessage GitlabDeferredMessage) {
+	schedule := g.scheduleFor("", deferredMessage.Username)
+	if schedule == nil || schedule.isWorkingTime(time.Now()) {
+		sendDirectMessage(deferredMessage.Username, deferredMessage.Message)
+		return
+	}
+	robot.Store().Unscoped().Where(&GitlabDeferredMessage{
+		NotificationID: deferredMessage.NotificationID,
+		Username: deferredMessage.Username,
+	}).Delete(GitlabDeferredMessage{})
+	deferredMessage.SendAt = schedule.nextWorkingTime(time.Now())
+	robot.Store().Create(&deferredMessage)
+}
+func (g GitlabApp) sendDeferredMessages() {
+	var deferredMessages []GitlabDeferredMessage
+	robot.Store().Where("send_at <= ?", time.Now()).Find(&deferredMessages)
+	for _, deferredMessage := range deferredMessages {
+		if !isDeferredMessageRelevant(deferredMessage) {
+			robot.Store().Unscoped().Delete(&deferredMessage)
+			continue
+		}
+		if deferredMessage.Username != "" {
+			sendDirectMessage(deferredMessage.Username, deferredMessage.Message)
+		} else {
+			robot.SendMessages(robot.Envelop{
+				ChannelName: deferredMessage.ChannelName,
+			}, deferredMessage.Message)
+		}
+		robot.Store().Unscoped().Delete(&deferredMessage)
+	}
+}
+
+// isDeferredMessageRelevant returns false when the notification of a deferred
+// message was closed or snoozed since, or claimed for a reminder.
+func isDeferredMessageRelevant(deferredMessage GitlabDeferredMessage) bool {
+	if deferredMessage.NotificationID == 0 {
+		return true
+	}
+	var notif GitlabNotification
+	robot.Store().Where("id = ?", deferredMessage.NotificationID).First(&notif)
+	if notif.ID == 0 || notif.IsSnoozed() {
+		return false
+	}
+	return !deferredMessage.Reminder || notif.AssignedUser == ""
+}
+// parseHourMinute returns the number of minutes since midnight of an hour in
+// format HH:MM, 24:00 is accepted as end of day.
+func parseHourMinute(value string) (int, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("Invalid hour '%s', it must be in format HH:MM.", value)
+	}
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil || hour < 0 || hour > 24 {
+		return 0, fmt.Errorf("Invalid hour '%s', it must be in format HH:MM.", value)
+	}
+	minute, err := strconv.Atoi(parts[1])
+	if err != nil || minute < 0 || minute > 59 {
+		return 0, fmt.Errorf("Invalid hour '%s', it must be in format HH:MM.", value)
+	}
+	// 24:00 is the end of the day, nothing goes past it
+	if hour == 24 && minute != 0 {
+		return 0, fmt.Errorf("Invalid hour '%s', it must be in format HH:MM.", value)
+	}
+	return hour * 60 + minute, nil
+}
+func parseWeekday(day string) (time.Weekday, error) {
+	day = strings.ToLower(day)
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		if strings.HasPrefix(strings.ToLower(weekday.String()), day) && len(day) >= 3 {
+			return weekday, nil
+		}
+	}
+	return time.Sunday, fmt.Errorf("Invalid week day '%s'.", day)
+}
+
+// loadIcsHolidays returns every day covered by events of an ics file in
+// format YYYY-MM-DD.
+func loadIcsHolidays(icsFile string) ([]string, error) {
+	file, err := os.Open(icsFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		// lines starting by a space or a tab are folded lines
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines) - 1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	holidays := make([]string, 0)
+	var start, end time.Time
+	for _, line := range lines {
+		switch {
+		case line == "BEGIN:VEVENT":
+			start = time.Time{}
+			end = time.Time{}
+		case strings.HasPrefix(line, "DTSTART"):
+			start, err = parseIcsDate(line)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid ics file %s: %s", icsFile, err.Error())
+			}
+		case strings.HasPrefix(line, "DTEND"):
+			end, err = parseIcsDate(line)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid ics file %s: %s", icsFile, err.Error())
+			}
+		case line == "END:VEVENT":
+			if start.IsZero() {
+				continue
+			}
+			holidays = append(holidays, start.Format(DATE_FORMAT))
+			// DTEND is exclusive for all day events
+			for day := start.AddDate(0, 0, 1); day.Before(end); day = day.AddDate(0, 0, 1) {
+				holidays = append(holidays, day.Format(DATE_FORMAT))
+			}
+		}
+	}
+	return holidays, nil
+}
+func parseIcsDate(line string) (time.Time, error) {
+	index := strings.LastIndex(line, ":")
+	if index < 0 {
+		return time.Time{}, fmt.Errorf("no value in '%s'", line)
+	}
+	value := line[index + 1:]
+	if len(value) < 8 {
+		return time.Time{}, fmt.Errorf("invalid date in '%s'", line)
+	}
+	return time.Parse("20060102", value[:8])
+}
@@ -0,0 +1,119 @@
+package gubot_gitlab
+
+import (
+	"testing"
+	"time"
+)
+
+var everyDay = []string{"mon", "tue", "wed", "thu", "fri", "sat", "sun"}
+
+func testSchedule(t *testing.T, conf GitlabWorkingHours) *workingSchedule {
+	conf.TimeZone = "UTC"
+	schedule, err := newWorkingSchedule(conf)
+	if err != nil {
+		t.Fatalf("newWorkingSchedule failed: %s", err.Error())
+	}
+	return schedule
+}
+
+// 2024-01-01 is a monday
+func testTime(day, hour, minute int) time.Time {
+	return time.Date(2024, 1, day, hour, minute, 0, 0, time.UTC)
+}
+func TestParseHourMinute(t *testing.T) {
+	tests := []struct {
+		value   string
+		minutes int
+		invalid bool
+	}{
+		{value: "00:00", minutes: 0},
+		{value: "09:30", minutes: 9 * 60 + 30},
+		{value: "23:59", minutes: 23 * 60 + 59},
+		{value: "24:00", minutes: 24 * 60},
+		{value: "24:30", invalid: true},
+		{value: "25:00", invalid: true},
+		{value: "12:60", invalid: true},
+		{value: "-1:00", invalid: true},
+		{value: "9h", invalid: true},
+	}
+	for _, test := range tests {
+		minutes, err := parseHourMinute(test.value)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("parseHourMinute(%q) should fail", test.value)
+			}
+			continue
+		}
+		if err != nil || minutes != test.minutes {
+			t.Errorf("parseHourMinute(%q) = %d, %v, want %d", test.value, minutes, err, test.minutes)
+		}
+	}
+}
+func TestInQuietHours(t *testing.T) {
+	tests := []struct {
+		quietStart string
+		quietEnd   string
+		at         time.Time
+		quiet      bool
+	}{
+		{quietStart: "12:00", quietEnd: "13:00", at: testTime(1, 11, 59), quiet: false},
+		{quietStart: "12:00", quietEnd: "13:00", at: testTime(1, 12, 0), quiet: true},
+		{quietStart: "12:00", quietEnd: "13:00", at: testTime(1, 13, 0), quiet: false},
+		{quietStart: "22:00", quietEnd: "07:00", at: testTime(1, 21, 59), quiet: false},
+		{quietStart: "22:00", quietEnd: "07:00", at: testTime(1, 23, 0), quiet: true},
+		{quietStart: "22:00", quietEnd: "07:00", at: testTime(1, 3, 0), quiet: true},
+		{quietStart: "22:00", quietEnd: "07:00", at: testTime(1, 7, 0), quiet: false},
+	}
+	for _, test := range tests {
+		schedule := testSchedule(t, GitlabWorkingHours{
+			QuietStart: test.quietStart,
+			QuietEnd: test.quietEnd,
+		})
+		if quiet := schedule.inQuietHours(test.at); quiet != test.quiet {
+			t.Errorf("inQuietHours(%s) with quiet hours %s-%s = %v, want %v", test.at, test.quietStart, test.quietEnd, quiet, test.quiet)
+		}
+	}
+}
+func TestNextWorkingTime(t *testing.T) {
+	officeHours := GitlabWorkingHours{
+		Start: "09:00",
+		End: "18:00",
+	}
+	lunchBreak := GitlabWorkingHours{
+		Start: "09:00",
+		End: "18:00",
+		QuietStart: "12:00",
+		QuietEnd: "13:00",
+	}
+	nights := GitlabWorkingHours{
+		WorkDays: everyDay,
+		QuietStart: "22:00",
+		QuietEnd: "07:00",
+	}
+	tests := []struct {
+		name     string
+		conf     GitlabWorkingHours
+		at       time.Time
+		expected time.Time
+	}{
+		{name: "during working hours", conf: officeHours, at: testTime(1, 10, 0), expected: testTime(1, 10, 0)},
+		{name: "before start", conf: officeHours, at: testTime(1, 8, 0), expected: testTime(1, 9, 0)},
+		{name: "after end", conf: officeHours, at: testTime(1, 19, 0), expected: testTime(2, 9, 0)},
+		{name: "friday evening", conf: officeHours, at: testTime(5, 19, 0), expected: testTime(8, 9, 0)},
+		{name: "weekend", conf: officeHours, at: testTime(6, 12, 0), expected: testTime(8, 9, 0)},
+		{name: "in quiet hours", conf: lunchBreak, at: testTime(1, 12, 30), expected: testTime(1, 13, 0)},
+		{name: "before quiet hours", conf: lunchBreak, at: testTime(1, 11, 0), expected: testTime(1, 11, 0)},
+		{name: "quiet hours before midnight", conf: nights, at: testTime(1, 23, 0), expected: testTime(2, 7, 0)},
+		{name: "quiet hours after midnight", conf: nights, at: testTime(2, 3, 0), expected: testTime(2, 7, 0)},
+		{name: "outside quiet hours", conf: nights, at: testTime(1, 21, 0), expected: testTime(1, 21, 0)},
+	}
+	for _, test := range tests {
+		schedule := testSchedule(t, test.conf)
+		if next := schedule.nextWorkingTime(test.at); !next.Equal(test.expected) {
+			t.Errorf("%s: nextWorkingTime(%s) = %s, want %s", test.name, test.at, next, test.expected)
+		}
+		if working := schedule.isWorkingTime(test.at); working != test.at.Equal(test.expected) {
+			t.Errorf("%s: isWorkingTime(%s) = %v", test.name, test.at, working)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package gubot_gitlab
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ArthurHlt/gubot/robot"
+	"github.com/urfave/cli"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const SNOOZE_DATE_FORMAT = "2006-01-02 15:04"
+
+func (g GitlabApp) cmdSnooze(c *cli.Context) error {
+	notif, err := g.notifFromRef(c.Args().First())
+	if err != nil {
+		fmt.Fprintln(c.App.Writer, err.Error())
+		return nil
+	}
+	args := c.Args().Tail()
+	if len(args) > 0 && strings.ToLower(args[0]) == "until" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		fmt.Fprintln(c.App.Writer, "I need a duration (e.g.: 2h, 3d, 1w) or a date (e.g.: 2006-01-02 or 2006-01-02T15:04) to snooze.")
+		return nil
+	}
+	snoozedUntil, err := parseSnoozeTime(args[0], time.Now())
+	if err != nil {
+		fmt.Fprintln(c.App.Writer, err.Error())
+		return nil
+	}
+	notif.SnoozedUntil = &snoozedUntil
+	notif.SnoozeReason = strings.Join(args[1:], " ")
+	robot.Store().Save(notif)
+	fmt.Fprintf(c.App.Writer,
+		"%s with id %d available here: %s is snoozed until %s.\n",
+		strings.Replace(notif.Type, "_", " ", -1),
+		notif.ID,
+		notif.WebUrl,
+		snoozedUntil.Format(SNOOZE_DATE_FORMAT),
+	)
+	return nil
+}
+func (g GitlabApp) cmdUnsnooze(c *cli.Context) error {
+	notif, err := g.notifFromRef(c.Args().First())
+	if err != nil {
+		fmt.Fprintln(c.App.Writer, err.Error())
+		return nil
+	}
+	if !notif.IsSnoozed() {
+		fmt.Fprintf(c.App.Writer, "%s with id %d is not snoozed.\n", strings.Replace(notif.Type, "_", " ", -1), notif.ID)
+		return nil
+	}
+	notif.SnoozedUntil = nil
+	notif.SnoozeReason = ""
+	robot.Store().Save(notif)
+	fmt.Fprintf(c.App.Writer,
+		"%s with id %d available here: %s is not snoozed anymore.\n",
+		strings.Replace(notif.Type, "_", " ", -1),
+		notif.ID,
+		notif.WebUrl,
+	)
+	return nil
+}
+
+// parseSnoozeTime parses a duration like 30m, 2h, 3d or 1w or a date like
+// 2006-01-02 or 2006-01-02T15:04 in local time.
+func parseSnoozeTime(value string, now time.Time) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02T15:04", "2006-01-02"} {
+		date, err := time.ParseInLocation(layout, value, time.Local)
+		if err == nil {
+			if !date.After(now) {
+				return date, errors.New("You can't snooze until a date in the past.")
+			}
+			return date, nil
+		}
+	}
+	multipliers := map[string]time.Duration{
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+	}
+	for unit, multiplier := range multipliers {
+		if !strings.HasSuffix(value, unit) {
+			continue
+		}
+		number, err := strconv.Atoi(strings.TrimSuffix(value, unit))
+		if err != nil || number <= 0 {
+			return now, errors.New("You gave me an incorrect duration.")
+		}
+		return now.Add(time.Duration(number) * multiplier), nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return now, errors.New("You gave me an incorrect duration or date.")
+	}
+	return now.Add(duration), nil
+}
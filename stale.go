@@ -0,0 +1,169 @@
+package gubot_gitlab
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ArthurHlt/gubot/robot"
+	"github.com/urfave/cli"
+	"sort"
+	"time"
+)
+
+func (r GitlabStaleRule) match(notif GitlabNotification) bool {
+	if len(r.Events) > 0 && !inSlice(r.Events, notif.Type) {
+		return false
+	}
+	if len(r.Paths) == 0 {
+		return true
+	}
+	_, ok := matchPath(r.Paths, notif.ProjectPath)
+	return ok
+}
+
+// lastActivity returns the time of the last activity seen in gitlab, it's the
+// time the notification was queued when nothing happened since.
+func (n GitlabNotification) lastActivity() time.Time {
+	if n.LastActivityAt != nil && n.LastActivityAt.After(n.CreatedAt) {
+		return *n.LastActivityAt
+	}
+	return n.CreatedAt
+}
+
+// staleDays returns the number of days without activity after which the
+// notification is stale, 0 means it never goes stale.
+func (g GitlabApp) staleDays(notif GitlabNotification) int {
+	for _, rule := range g.conf.GitlabStaleRules {
+		if rule.match(notif) {
+			return rule.AfterDays
+		}
+	}
+	return g.conf.GitlabStaleInDays
+}
+
+// recordActivity keeps the time of an event received from gitlab on a merge
+// request or an issue in queue.
+func (g GitlabApp) recordActivity(projectID, objectIid int, notifType string) {
+	robot.Store().Model(&GitlabNotification{}).Where(&GitlabNotification{
+		ProjectID: projectID,
+		ObjectIid: objectIid,
+		Type: notifType,
+	}).UpdateColumn("last_activity_at", time.Now())
+}
+func (g GitlabApp) recordNoteActivity(webhook []byte) {
+	var event noteEvent
+	json.Unmarshal(webhook, &event)
+	switch event.ObjectAttributes.NoteableType {
+	case "MergeRequest":
+		g.recordActivity(event.ProjectID, event.MergeRequest.IID, MERGE_REQUEST_EVENT_NAME)
+	case "Issue":
+		g.recordActivity(event.ProjectID, event.Issue.IID, ISSUE_EVENT_NAME)
+	}
+}
+
+// nudgeStale reports in their channel assigned merge requests and issues
+// which went stale and sends a direct message to their assignee, it's done
+// again each time the stale period passes without activity.
+func (g GitlabApp) nudgeStale() {
+	var notifs []GitlabNotification
+	robot.Store().Where("assigned_user <> ?", "").Order("project_id asc").Find(&notifs)
+	now := time.Now()
+	staleByChannel := make(map[string][]GitlabNotification)
+	for _, notif := range notifs {
+		if notif.IsSnoozed() || g.isFilteredRepo(notif.ProjectPath) || !g.isStale(notif, now) {
+			continue
+		}
+		if !g.isStaleNudgeDue(notif, now) || !g.isWorkingTime(notif.ChannelName, "") {
+			continue
+		}
+		// activity which doesn't trigger webhooks (e.g.: pushes) is only seen in gitlab
+		g.refreshActivity(&notif)
+		if !g.isStale(notif, now) {
+			continue
+		}
+		notif.StaleNotifiedAt = &now
+		robot.Store().Save(&notif)
+		staleByChannel[notif.ChannelName] = append(staleByChannel[notif.ChannelName], notif)
+		ref := refFromNotif(&notif)
+		g.sendDirectOrDefer(g.retrieveChatUser(notif.AssignedUser), fmt.Sprintf(
+			"[%s](%s) assigned to you had no activity in gitlab for %s, is it still in progress? " +
+				"You can snooze it with: gitlab snooze %s <2h|3d|until 2006-01-02> [reason]",
+			ref,
+			notif.WebUrl,
+			formatAge(now.Sub(notif.lastActivity())),
+			ref,
+		), notif.ID)
+	}
+	for channelName, staleNotifs := range staleByChannel {
+		robot.SendMessages(robot.Envelop{
+			ChannelName: channelName,
+		}, "**Assigned but without activity:**\n" + g.formatStale(staleNotifs, now))
+	}
+}
+func (g GitlabApp) isStaleNudgeDue(notif GitlabNotification, at time.Time) bool {
+	if notif.StaleNotifiedAt == nil || notif.StaleNotifiedAt.Before(notif.lastActivity()) {
+		return true
+	}
+	nextNudge := notif.StaleNotifiedAt.Add(time.Duration(g.staleDays(notif)) * 24 * time.Hour)
+	return !nextNudge.After(at)
+}
+
+// refreshActivity updates the last activity of a notification with the last
+// update of the merge request or the issue in gitlab.
+func (g GitlabApp) refreshActivity(notif *GitlabNotification) {
+	var object struct {
+		UpdatedAt *time.Time `json:"updated_at"`
+	}
+	var err error
+	if notif.Type == MERGE_REQUEST_EVENT_NAME {
+		_, err = g.DoMergeRequestRequest(notif.ProjectID, notif.ObjectIid, "GET", "", nil, &object)
+	} else {
+		_, err = g.DoIssueRequest(notif.ProjectID, notif.ObjectIid, "GET", "", nil, &object)
+	}
+	if err != nil {
+		robot.Logger().Error("Error when retrieving activity of %s: %s", notif.WebUrl, err.Error())
+		return
+	}
+	if object.UpdatedAt == nil || !object.UpdatedAt.After(notif.lastActivity()) {
+		return
+	}
+	notif.LastActivityAt = object.UpdatedAt
+	robot.Store().Save(notif)
+}
+func (g GitlabApp) cmdStale(c *cli.Context) error {
+	var notifs []GitlabNotification
+	robot.Store().Find(&notifs)
+	now := time.Now()
+	stale := make([]GitlabNotification, 0)
+	for _, notif := range notifs {
+		if notif.IsSnoozed() || g.isFilteredRepo(notif.ProjectPath) || !g.isStale(notif, now) {
+			continue
+		}
+		stale = append(stale, notif)
+	}
+	if len(stale) == 0 {
+		fmt.Fprint(c.App.Writer, "There is no stale merge requests or issues in queue.")
+		return nil
+	}
+	sort.Slice(stale, func(i, j int) bool {
+		return stale[i].lastActivity().Before(stale[j].lastActivity())
+	})
+	fmt.Fprint(c.App.Writer, "**Stale merge requests and issues:**\n" + g.formatStale(stale, now))
+	return nil
+}
+func (g GitlabApp) formatStale(notifs []GitlabNotification, now time.Time) string {
+	message := ""
+	for _, notif := range notifs {
+		assignee := "waiting for someone"
+		if notif.AssignedUser != "" {
+			assignee = "assigned to @" + g.retrieveChatUser(notif.AssignedUser)
+		}
+		message += fmt.Sprintf(
+			"- [%s](%s) %s, no activity for %s\n",
+			refFromNotif(&notif),
+			notif.WebUrl,
+			assignee,
+			formatAge(now.Sub(notif.lastActivity())),
+		)
+	}
+	return message
+}
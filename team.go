@@ -0,0 +1,233 @@
+package gubot_gitlab
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ArthurHlt/gubot/robot"
+	"github.com/urfave/cli"
+	"strings"
+)
+
+const TEAM_PREFIX = "team:"
+
+func (t GitlabTeam) MemberList() []string {
+	return splitList(t.Members)
+}
+func (t GitlabTeam) PathList() []string {
+	return splitList(t.Paths)
+}
+func (t GitlabTeam) ownPath(projectPath string) (string, bool) {
+	return matchPath(t.PathList(), projectPath)
+}
+func (g GitlabApp) seedTeams() {
+	for _, teamConf := range g.conf.GitlabTeams {
+		if teamConf.Name == "" {
+			continue
+		}
+		var team GitlabTeam
+		robot.Store().Where(&GitlabTeam{Name: teamConf.Name}).First(&team)
+		if team.ID != 0 {
+			continue
+		}
+		members := make([]string, 0)
+		for _, member := range teamConf.Members {
+			members = append(members, strings.TrimPrefix(member, "@"))
+		}
+		robot.Store().Create(&GitlabTeam{
+			Name: teamConf.Name,
+			Members: strings.Join(members, ","),
+			Paths: strings.Join(teamConf.Paths, ","),
+			Channel: teamConf.Channel,
+		})
+	}
+}
+func (g GitlabApp) findTeam(name string) *GitlabTeam {
+	var team GitlabTeam
+	robot.Store().Where(&GitlabTeam{Name: name}).First(&team)
+	if team.ID == 0 {
+		return nil
+	}
+	return &team
+}
+
+// teamForProject returns the team owning the given project path, the team
+// with the most specific path wins when several teams own the project.
+func (g GitlabApp) teamForProject(projectPath string) *GitlabTeam {
+	if projectPath == "" {
+		return nil
+	}
+	var teams []GitlabTeam
+	robot.Store().Find(&teams)
+	var owner *GitlabTeam
+	ownerPath := ""
+	for i, team := range teams {
+		teamPath, ok := team.ownPath(projectPath)
+		if !ok || len(teamPath) <= len(ownerPath) {
+			continue
+		}
+		owner = &teams[i]
+		ownerPath = teamPath
+	}
+	return owner
+}
+
+// channelForProject returns the channel of the team owning the project or
+// the default notification channel.
+func (g GitlabApp) channelForProject(projectPath string) string {
+	team := g.teamForProject(projectPath)
+	if team == nil || team.Channel == "" {
+		return g.conf.GitlabNotifyChannel
+	}
+	return team.Channel
+}
+
+// pickTeamMember returns the team member with the least merge requests and
+// issues assigned in queue.
+func (g GitlabApp) pickTeamMember(name string) (string, error) {
+	team := g.findTeam(name)
+	if team == nil {
+		return "", errors.New("Team " + name + " not found.")
+	}
+	members := team.MemberList()
+	if len(members) == 0 {
+		return "", errors.New("Team " + name + " doesn't have any member.")
+	}
+	picked := ""
+	pickedCount := -1
+	for _, member := range members {
+		var count int
+		robot.Store().Model(&GitlabNotification{}).Where(&GitlabNotification{
+			AssignedUser: member,
+		}).Count(&count)
+		if pickedCount == -1 || count < pickedCount {
+			picked = member
+			pickedCount = count
+		}
+	}
+	return picked, nil
+}
+func (g GitlabApp) cmdTeamList(c *cli.Context) error {
+	var teams []GitlabTeam
+	robot.Store().Order("name asc").Find(&teams)
+	if len(teams) == 0 {
+		fmt.Fprint(c.App.Writer, "There is no team defined.")
+		return nil
+	}
+	for _, team := range teams {
+		fmt.Fprint(c.App.Writer, teamDescription(team))
+	}
+	return nil
+}
+func (g GitlabApp) cmdTeamShow(c *cli.Context) error {
+	team := g.teamFromCommand(c)
+	if team == nil {
+		return nil
+	}
+	fmt.Fprint(c.App.Writer, teamDescription(*team))
+	return nil
+}
+func (g GitlabApp) cmdTeamCreate(c *cli.Context) error {
+	name := c.Args().First()
+	if name == "" {
+		fmt.Fprintln(c.App.Writer, "I need a team name.")
+		return nil
+	}
+	if g.findTeam(name) != nil {
+		fmt.Fprintf(c.App.Writer, "Team %s already exists.\n", name)
+		return nil
+	}
+	robot.Store().Create(&GitlabTeam{
+		Name: name,
+	})
+	fmt.Fprintf(c.App.Writer, "Team %s has been created.\n", name)
+	return nil
+}
+func (g GitlabApp) cmdTeamDelete(c *cli.Context) error {
+	team := g.teamFromCommand(c)
+	if team == nil {
+		return nil
+	}
+	robot.Store().Unscoped().Delete(team)
+	fmt.Fprintf(c.App.Writer, "Team %s has been deleted.\n", team.Name)
+	return nil
+}
+func (g GitlabApp) cmdTeamAddMember(c *cli.Context) error {
+	g.updateTeamList(c, func(team *GitlabTeam, values []string) {
+		team.Members = strings.Join(addToList(team.MemberList(), values), ",")
+	})
+	return nil
+}
+func (g GitlabApp) cmdTeamRemoveMember(c *cli.Context) error {
+	g.updateTeamList(c, func(team *GitlabTeam, values []string) {
+		team.Members = strings.Join(removeFromList(team.MemberList(), values), ",")
+	})
+	return nil
+}
+func (g GitlabApp) cmdTeamAddPath(c *cli.Context) error {
+	g.updateTeamList(c, func(team *GitlabTeam, values []string) {
+		team.Paths = strings.Join(addToList(team.PathList(), values), ",")
+	})
+	return nil
+}
+func (g GitlabApp) cmdTeamRemovePath(c *cli.Context) error {
+	g.updateTeamList(c, func(team *GitlabTeam, values []string) {
+		team.Paths = strings.Join(removeFromList(team.PathList(), values), ",")
+	})
+	return nil
+}
+func (g GitlabApp) cmdTeamSetChannel(c *cli.Context) error {
+	team := g.teamFromCommand(c)
+	if team == nil {
+		return nil
+	}
+	// without channel notifications go back to the default channel
+	team.Channel = c.Args().Get(1)
+	robot.Store().Save(team)
+	fmt.Fprint(c.App.Writer, teamDescription(*team))
+	return nil
+}
+func (g GitlabApp) updateTeamList(c *cli.Context, update func(team *GitlabTeam, values []string)) {
+	team := g.teamFromCommand(c)
+	if team == nil {
+		return
+	}
+	values := make([]string, 0)
+	for _, value := range c.Args().Tail() {
+		values = append(values, strings.TrimPrefix(value, "@"))
+	}
+	if len(values) == 0 {
+		fmt.Fprintln(c.App.Writer, "I need at least one value to update the team.")
+		return
+	}
+	update(team, values)
+	robot.Store().Save(team)
+	fmt.Fprint(c.App.Writer, teamDescription(*team))
+}
+func (g GitlabApp) teamFromCommand(c *cli.Context) *GitlabTeam {
+	name := c.Args().First()
+	if name == "" {
+		fmt.Fprintln(c.App.Writer, "I need a team name.")
+		return nil
+	}
+	team := g.findTeam(name)
+	if team == nil {
+		fmt.Fprintf(c.App.Writer, "Team %s not found.\n", name)
+		return nil
+	}
+	return team
+}
+func teamDescription(team GitlabTeam) string {
+	members := "no members"
+	if len(team.MemberList()) > 0 {
+		members = "@" + strings.Join(team.MemberList(), ", @")
+	}
+	paths := "no projects"
+	if len(team.PathList()) > 0 {
+		paths = strings.Join(team.PathList(), ", ")
+	}
+	channel := ""
+	if team.Channel != "" {
+		channel = " -- notified in " + team.Channel
+	}
+	return fmt.Sprintf("- Team **%s**: %s -- owns %s%s\n", team.Name, members, paths, channel)
+}
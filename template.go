@@ -0,0 +1,230 @@
+package gubot_gitlab
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/ArthurHlt/gubot/robot"
+	"github.com/urfave/cli"
+	"strings"
+	"text/template"
+)
+
+const QUEUE_ITEM_TEMPLATE_NAME = "queue_item"
+
+// Templates receive a TemplateData for merge_request, issue, build and
+// pipeline events and a QueueItemTemplateData for queue_item which is a line
+// in queue lists. Templates are go text/template, e.g.:
+// {{.Author}} opened [{{.Title}}]({{.Url}}) on {{.Project.Path}}{{if .Labels}} ({{join .Labels ", "}}){{end}}
+var defaultTemplates = map[string]string{
+	MERGE_REQUEST_EVENT_NAME: "**Merge request** on project [{{.Project.Path}}]({{.Url}}) from @{{.Author}}, [click here]({{.Url}}), title: \n> {{.Title}}",
+	ISSUE_EVENT_NAME: "**Issue** on project [{{.Project.Path}}]({{.Url}}) from @{{.Author}}, [click here]({{.Url}}), title: \n> {{.Title}}",
+	BUILD_EVENT_NAME: "Build failed on project [{{.Project.Name}}]({{.Project.Url}})" +
+		"{{if .Build.FlakyCount}} (this job is known flaky, it failed then passed on retry {{.Build.FlakyCount}} time(s) lately){{end}}" +
+		"{{if .Build.RetryCommand}}, retry it with `{{.Build.RetryCommand}}`{{end}}" +
+		"{{if .Build.LogExcerpt}}\n```\n{{.Build.LogExcerpt}}\n```{{end}}",
+	PIPELINE_EVENT_NAME: "Pipeline failed on project [{{.Project.Name}}]({{.Project.Url}})" +
+		"{{if .Pipeline.RetryCommand}}, retry it with `{{.Pipeline.RetryCommand}}`{{end}}",
+	QUEUE_ITEM_TEMPLATE_NAME: "{{.Type}}: [#{{.ID}}]({{.Url}}) ({{.Age}})" +
+		"{{if .Snoozed}} -- snoozed until {{.SnoozedUntil}}{{if .SnoozeReason}}: {{.SnoozeReason}}{{end}}" +
+		"{{else if .ShowAssigned}}{{if .AssignedUser}} -- Assigned to {{.AssignedUser}}" +
+		"{{else}} -- this {{.Type}} is not assigned, assign to you by doing `gitlab {{.Command}} assign me {{.ID}}`{{end}}{{end}}",
+}
+
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+type TemplateProject struct {
+	Name string
+	Path string
+	Url  string
+}
+type TemplatePipeline struct {
+	ID           int
+	Status       string
+	Ref          string
+	Sha          string
+	Url          string
+	RetryCommand string
+}
+type TemplateBuild struct {
+	ID           int
+	Name         string
+	Stage        string
+	Status       string
+	Url          string
+	RetryCommand string
+	// LogExcerpt is the first error or the last lines of the job log when
+	// GitlabJobLogInNotifications is set
+	LogExcerpt   string
+	// FlakyCount is the number of flaky failures of the job lately
+	FlakyCount   int
+}
+
+// TemplateData is the data given to event templates, Author and Assignee are
+// chat user names.
+type TemplateData struct {
+	Event        string
+	Project      TemplateProject
+	Author       string
+	Assignee     string
+	Title        string
+	Url          string
+	Iid          int
+	State        string
+	Labels       []string
+	SourceBranch string
+	TargetBranch string
+	Pipeline     TemplatePipeline
+	Build        TemplateBuild
+}
+
+// QueueItemTemplateData is the data given to the queue_item template, Type is
+// human readable (e.g.: merge request) and Command is the command name to use
+// (e.g.: merge-request).
+type QueueItemTemplateData struct {
+	ID           uint
+	Type         string
+	Command      string
+	Url          string
+	Age          string
+	ProjectName  string
+	AssignedUser string
+	ShowAssigned bool
+	Snoozed      bool
+	SnoozedUntil string
+	SnoozeReason string
+}
+
+func parseMessageTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Parse(text)
+}
+
+// loadTemplates parses default templates and templates from config and
+// validates them by rendering sample data.
+func (g *GitlabApp) loadTemplates() error {
+	g.templates = make(map[string]*template.Template)
+	for event, text := range defaultTemplates {
+		tpl, err := parseMessageTemplate(event, text)
+		if err != nil {
+			return err
+		}
+		g.templates[templateKey(event, "")] = tpl
+	}
+	for _, conf := range g.conf.GitlabTemplates {
+		if _, ok := defaultTemplates[conf.Event]; !ok {
+			return fmt.Errorf("Unknown event '%s' for template.", conf.Event)
+		}
+		tpl, err := parseMessageTemplate(conf.Event, conf.Template)
+		if err != nil {
+			return err
+		}
+		err = tpl.Execute(new(bytes.Buffer), sampleTemplateData(conf.Event))
+		if err != nil {
+			return err
+		}
+		g.templates[templateKey(conf.Event, conf.Channel)] = tpl
+	}
+	return nil
+}
+func templateKey(event, channelName string) string {
+	return event + "/" + channelName
+}
+
+// renderTemplate renders the template of an event for a channel, it falls
+// back on the template for every channels and on the default template.
+func (g GitlabApp) renderTemplate(event, channelName string, data interface{}) string {
+	tpl, ok := g.templates[templateKey(event, channelName)]
+	if !ok {
+		tpl = g.templates[templateKey(event, "")]
+	}
+	buf := new(bytes.Buffer)
+	err := tpl.Execute(buf, data)
+	if err == nil {
+		return buf.String()
+	}
+	robot.Logger().Error("Error when rendering template for %s: %s", event, err.Error())
+	buf.Reset()
+	defaultTpl, _ := parseMessageTemplate(event, defaultTemplates[event])
+	defaultTpl.Execute(buf, data)
+	return buf.String()
+}
+func (g GitlabApp) cmdTemplatePreview(envelop robot.Envelop, c *cli.Context) error {
+	event := strings.Replace(c.Args().First(), "-", "_", -1)
+	if _, ok := defaultTemplates[event]; !ok {
+		events := make([]string, 0)
+		for event, _ := range defaultTemplates {
+			events = append(events, strings.Replace(event, "_", "-", -1))
+		}
+		fmt.Fprintf(c.App.Writer, "I need one of these events to preview a template: %s\n", strings.Join(events, ", "))
+		return nil
+	}
+	fmt.Fprint(c.App.Writer, g.renderTemplate(event, envelop.ChannelName, sampleTemplateData(event)))
+	return nil
+}
+func sampleTemplateData(event string) interface{} {
+	if event == QUEUE_ITEM_TEMPLATE_NAME {
+		return QueueItemTemplateData{
+			ID: 42,
+			Type: "merge request",
+			Command: "merge-request",
+			Url: "https://gitlab.example.com/group/project/merge_requests/12",
+			Age: "3d",
+			ProjectName: "project",
+			ShowAssigned: true,
+		}
+	}
+	return TemplateData{
+		Event: event,
+		Project: TemplateProject{
+			Name: "project",
+			Path: "group/project",
+			Url: "https://gitlab.example.com/group/project",
+		},
+		Author: "john",
+		Assignee: "jane",
+		Title: "Add a new feature",
+		Url: "https://gitlab.example.com/group/project/merge_requests/12",
+		Iid: 12,
+		State: "opened",
+		Labels: []string{"feature", "backend"},
+		SourceBranch: "feature",
+		TargetBranch: "master",
+		Pipeline: TemplatePipeline{
+			ID: 1234,
+			Status: "failed",
+			Ref: "master",
+			Sha: "0123456789abcdef",
+			Url: "https://gitlab.example.com/group/project/pipelines/1234",
+			RetryCommand: pipelineRetryCommand("group/project", 1234),
+		},
+		Build: TemplateBuild{
+			ID: 5678,
+			Name: "test",
+			Stage: "test",
+			Status: "failed",
+			Url: "https://gitlab.example.com/group/project/builds/5678",
+			RetryCommand: jobRetryCommand(5678),
+			FlakyCount: 2,
+			LogExcerpt: "--- FAIL: TestFeature (0.01s)\n    feature_test.go:12: expected true, got false",
+		},
+	}
+}
+
+// labelsFromWebhook retrieves labels titles sent in a webhook payload.
+func labelsFromWebhook(webhook []byte) []string {
+	var labelsEvent struct {
+		Labels []struct {
+			Title string `json:"title"`
+		} `json:"labels"`
+	}
+	json.Unmarshal(webhook, &labelsEvent)
+	labels := make([]string, 0)
+	for _, label := range labelsEvent.Labels {
+		labels = append(labels, label.Title)
+	}
+	return labels
+}
@@ -0,0 +1,105 @@
+package gubot_gitlab
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ArthurHlt/gubot/robot"
+)
+
+// findQueuedNotif returns the notification in queue for a merge request or
+// an issue or nil if there is none, whatever the channel it was sent in.
+func (g GitlabApp) findQueuedNotif(projectID, objectId int, notifType string) *GitlabNotification {
+	var notif GitlabNotification
+	robot.Store().Where(&GitlabNotification{
+		ProjectID: projectID,
+		ObjectId: objectId,
+		Type: notifType,
+	}).First(&notif)
+	if notif.ID == 0 {
+		return nil
+	}
+	return &notif
+}
+
+// notifyRoot sends the first notification of a merge request or an issue,
+// when the renderer supports threads the chat message id is kept to follow up
+// next events in its thread.
+func (g GitlabApp) notifyRoot(notif *GitlabNotification) {
+	renderer, ok := g.renderer.(threadedRenderer)
+	if !ok || notif.AssignedUser != "" {
+		g.notify(notif)
+		return
+	}
+	mentions, err := g.mentions(*notif)
+	if err != nil {
+		robot.Logger().Error("Error when notifying: %s", err.Error())
+		return
+	}
+	channelId, messageId, err := renderer.SendRoot(notif.ChannelName, mentions, notif.Message, notif.Card)
+	if err != nil {
+		robot.Logger().Error("Error when sending rich message, falling back to markdown: %s", err.Error())
+		markdownRenderer{}.Send(notif.ChannelName, mentions, notif.Message, nil)
+		return
+	}
+	notif.ChatChannelId = channelId
+	notif.ChatMessageId = messageId
+	if notif.Card != nil {
+		cardData, _ := json.Marshal(notif.Card)
+		notif.CardData = string(cardData)
+	}
+	robot.Store().Save(notif)
+}
+
+// followUp replies in the thread of the first notification, nothing is sent
+// when the renderer doesn't support threads.
+func (g GitlabApp) followUp(notif GitlabNotification, message string) {
+	renderer, ok := g.renderer.(threadedRenderer)
+	if !ok || notif.ChatMessageId == "" {
+		return
+	}
+	err := renderer.Reply(notif.ChatChannelId, notif.ChatMessageId, message)
+	if err != nil {
+		robot.Logger().Error("Error when following up in thread: %s", err.Error())
+	}
+}
+
+// updateRoot edits the first notification to show the new status of the
+// merge request or issue.
+func (g GitlabApp) updateRoot(notif GitlabNotification, status string, color string) {
+	renderer, ok := g.renderer.(threadedRenderer)
+	if !ok || notif.ChatMessageId == "" {
+		return
+	}
+	var card *ChatCard
+	if notif.CardData != "" {
+		card = &ChatCard{}
+		json.Unmarshal([]byte(notif.CardData), card)
+		card.Color = color
+		card.Fields = append(card.Fields, ChatField{
+			Title: "Status",
+			Value: status,
+			Short: true,
+		})
+	}
+	err := renderer.Update(notif.ChatChannelId, notif.ChatMessageId, notif.Message + "\n\n**" + status + "**", card)
+	if err != nil {
+		robot.Logger().Error("Error when updating message: %s", err.Error())
+	}
+}
+
+// followUpPipeline replies in threads of merge requests with the source
+// branch of a failed pipeline.
+func (g GitlabApp) followUpPipeline(pipeline GitlabPipeline) {
+	if pipeline.Status != "failed" {
+		return
+	}
+	var notifs []GitlabNotification
+	robot.Store().Where(&GitlabNotification{
+		ProjectPath: pipeline.ProjectPath,
+		Type: MERGE_REQUEST_EVENT_NAME,
+		SourceBranch: pipeline.Ref,
+	}).Find(&notifs)
+	for _, notif := range notifs {
+		g.followUp(notif, fmt.Sprintf("Pipeline [#%d](%s) failed.", pipeline.PipelineID, pipeline.WebUrl))
+	}
+}
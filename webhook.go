@@ -33,15 +33,56 @@ func (g GitlabApp) incomingWebhook(w http.ResponseWriter, req *http.Request) {
 		g.notifyIssue(b)
 		break
 	case BUILD_EVENT_NAME:
-		g.notifyBuildFailed(b)
+		g.recordJob(b)
+		// job log is retrieved from gitlab for the notification
+		go g.notifyBuildFailed(b)
 		break
 	case PIPELINE_EVENT_NAME:
-		g.notifyBuildFailed(b)
+		g.recordPipeline(b)
+		g.notifyPipelineFailed(b)
+		break
+	case PUSH_EVENT_NAME:
+		g.checkMergeability(b)
+		break
+	case NOTE_EVENT_NAME:
+		g.recordNoteActivity(b)
+		g.relayNote(b)
 		break
 	default:
 		return
 	}
 }
+func (g GitlabApp) recordPipeline(webhook []byte) {
+	var pipelineEvent gitlab.PipelineEvent
+	json.Unmarshal(webhook, &pipelineEvent)
+	if g.isFilteredRepo(pipelineEvent.Project.PathWithNamespace) {
+		return
+	}
+	var pipeline GitlabPipeline
+	robot.Store().Where(&GitlabPipeline{
+		PipelineID: pipelineEvent.ObjectAttributes.ID,
+		ProjectPath: pipelineEvent.Project.PathWithNamespace,
+	}).First(&pipeline)
+	pipeline.PipelineID = pipelineEvent.ObjectAttributes.ID
+	pipeline.ProjectPath = pipelineEvent.Project.PathWithNamespace
+	pipeline.ProjectUrl = pipelineEvent.Project.WebURL
+	pipeline.Ref = pipelineEvent.ObjectAttributes.Ref
+	pipeline.Sha = pipelineEvent.ObjectAttributes.SHA
+	pipeline.Status = pipelineEvent.ObjectAttributes.Status
+	pipeline.Username = pipelineEvent.User.Username
+	pipeline.DefaultBranch = pipelineEvent.ObjectAttributes.Ref == pipelineEvent.Project.DefaultBranch
+	pipeline.Duration = pipelineEvent.ObjectAttributes.Duration
+	var queuedEvent struct {
+		ObjectAttributes struct {
+			QueuedDuration float64 `json:"queued_duration"`
+		} `json:"object_attributes"`
+	}
+	json.Unmarshal(webhook, &queuedEvent)
+	pipeline.QueuedDuration = int(queuedEvent.ObjectAttributes.QueuedDuration)
+	pipeline.WebUrl = fmt.Sprintf("%s/pipelines/%d", pipelineEvent.Project.WebURL, pipelineEvent.ObjectAttributes.ID)
+	robot.Store().Save(&pipeline)
+	g.followUpPipeline(pipeline)
+}
 func (g GitlabApp) notifyPipelineFailed(webhook []byte) {
 	var pipelineEvent gitlab.PipelineEvent
 	json.Unmarshal(webhook, &pipelineEvent)
@@ -52,19 +93,34 @@ func (g GitlabApp) notifyPipelineFailed(webhook []byte) {
 		return
 	}
 	notif := &GitlabNotification{
-		ProjectID: pipelineEvent.ObjectAttributes.ID,
 		ProjectName: pipelineEvent.Project.Name,
-		GroupName: pipelineEvent.Project.Namespace,
+		GroupName: namespaceFromPath(pipelineEvent.Project.PathWithNamespace),
+		ProjectPath: pipelineEvent.Project.PathWithNamespace,
 		Type: PIPELINE_EVENT_NAME,
 		ObjectId: pipelineEvent.ObjectAttributes.ID,
-		ChannelName: g.conf.GitlabNotifyChannel,
+		ChannelName: g.channelForProject(pipelineEvent.Project.PathWithNamespace),
 		WebUrl: pipelineEvent.Project.WebURL,
 	}
-	notif.Message = fmt.Sprintf(
-		"Pipeline failed on project [%s](%s)",
-		notif.ProjectName,
-		pipelineEvent.Project.WebURL,
-	)
+	data := TemplateData{
+		Event: PIPELINE_EVENT_NAME,
+		Project: TemplateProject{
+			Name: pipelineEvent.Project.Name,
+			Path: pipelineEvent.Project.PathWithNamespace,
+			Url: pipelineEvent.Project.WebURL,
+		},
+		Author: g.retrieveChatUser(pipelineEvent.User.Username),
+		Url: fmt.Sprintf("%s/pipelines/%d", pipelineEvent.Project.WebURL, pipelineEvent.ObjectAttributes.ID),
+		Pipeline: TemplatePipeline{
+			ID: pipelineEvent.ObjectAttributes.ID,
+			Status: pipelineEvent.ObjectAttributes.Status,
+			Ref: pipelineEvent.ObjectAttributes.Ref,
+			Sha: pipelineEvent.ObjectAttributes.SHA,
+			Url: fmt.Sprintf("%s/pipelines/%d", pipelineEvent.Project.WebURL, pipelineEvent.ObjectAttributes.ID),
+			RetryCommand: pipelineRetryCommand(pipelineEvent.Project.PathWithNamespace, pipelineEvent.ObjectAttributes.ID),
+		},
+	}
+	notif.Message = g.renderTemplate(PIPELINE_EVENT_NAME, notif.ChannelName, data)
+	notif.Card = newCard(data)
 
 	g.notify(notif)
 }
@@ -75,63 +131,118 @@ func (g GitlabApp) notifyBuildFailed(webhook []byte) {
 	if buildEvent.BuildStatus != "failed" {
 		return
 	}
-	if g.isFilteredRepo(buildEvent.Repository.PathWithNamespace) {
+	projectPath := buildProjectPath(webhook, buildEvent)
+	if g.isFilteredRepo(projectPath) {
 		return
 	}
 	notif := &GitlabNotification{
 		ProjectID: buildEvent.ProjectID,
 		ProjectName: buildEvent.Repository.Name,
-		GroupName: buildEvent.Repository.Namespace,
+		GroupName: namespaceFromPath(projectPath),
+		ProjectPath: projectPath,
 		Type: BUILD_EVENT_NAME,
 		ObjectId: buildEvent.BuildID,
-		ChannelName: g.conf.GitlabNotifyChannel,
+		ChannelName: g.channelForProject(projectPath),
 		WebUrl: buildEvent.Repository.HTTPURL,
 	}
-	notif.Message = fmt.Sprintf(
-		"Build failed on project [%s](%s)",
-		notif.ProjectName,
-		buildEvent.Repository.HTTPURL,
-	)
+	buildUrl := fmt.Sprintf("%s/builds/%d", buildEvent.Repository.Homepage, buildEvent.BuildID)
+	data := TemplateData{
+		Event: BUILD_EVENT_NAME,
+		Project: TemplateProject{
+			Name: buildEvent.Repository.Name,
+			Path: projectPath,
+			Url: buildEvent.Repository.HTTPURL,
+		},
+		Author: g.retrieveChatUser(buildUsername(webhook, buildEvent)),
+		Url: buildUrl,
+		Pipeline: TemplatePipeline{
+			Ref: buildEvent.Ref,
+			Sha: buildEvent.SHA,
+		},
+		Build: TemplateBuild{
+			ID: buildEvent.BuildID,
+			Name: buildEvent.BuildName,
+			Stage: buildEvent.BuildStage,
+			Status: buildEvent.BuildStatus,
+			Url: buildUrl,
+			RetryCommand: jobRetryCommand(buildEvent.BuildID),
+			LogExcerpt: g.jobLogExcerpt(buildEvent.ProjectID, buildEvent.BuildID),
+			FlakyCount: g.flakyCount(buildEvent.ProjectID, buildEvent.BuildName),
+		},
+	}
+	notif.Message = g.renderTemplate(BUILD_EVENT_NAME, notif.ChannelName, data)
+	notif.Card = newCard(data)
 
 	g.notify(notif)
 }
+
+// buildUsername returns the gitlab username of the user who triggered a job,
+// job webhooks of old gitlab versions only send the display name.
+func buildUsername(webhook []byte, buildEvent gitlab.BuildEvent) string {
+	var userEvent struct {
+		User struct {
+			Username string `json:"username"`
+		} `json:"user"`
+	}
+	json.Unmarshal(webhook, &userEvent)
+	if userEvent.User.Username != "" {
+		return userEvent.User.Username
+	}
+	return buildEvent.User.Name
+}
 func (g GitlabApp) notifyIssue(webhook []byte) {
 	var issueEvent gitlab.IssueEvent
 	json.Unmarshal(webhook, &issueEvent)
 	if g.isFilteredRepo(issueEvent.Project.PathWithNamespace) {
 		return
 	}
+	g.recordActivity(issueEvent.ObjectAttributes.ProjectID, issueEvent.ObjectAttributes.IID, ISSUE_EVENT_NAME)
 	notif := &GitlabNotification{
 		ProjectID: issueEvent.ObjectAttributes.ProjectID,
 		ProjectName: issueEvent.Project.Name,
-		GroupName: issueEvent.Project.Namespace,
+		GroupName: namespaceFromPath(issueEvent.Project.PathWithNamespace),
+		ProjectPath: issueEvent.Project.PathWithNamespace,
 		Type: ISSUE_EVENT_NAME,
 		ObjectId: issueEvent.ObjectAttributes.ID,
-		ChannelName: g.conf.GitlabNotifyChannel,
+		ObjectIid: issueEvent.ObjectAttributes.IID,
+		ChannelName: g.channelForProject(issueEvent.Project.PathWithNamespace),
 		WebUrl: issueEvent.ObjectAttributes.URL,
 		ProjectUrl: issueEvent.Project.Homepage,
 		AssignedUser: g.retrieveGitlabUser(issueEvent.Assignee.Username),
 	}
+	dbNotif := g.findQueuedNotif(notif.ProjectID, notif.ObjectId, notif.Type)
 	if issueEvent.ObjectAttributes.State == "closed" {
-		var count int
-		robot.Store().Model(&GitlabNotification{}).Where(notif).Count(&count)
-		if count > 0 {
-			robot.Store().Unscoped().Where(&notif).Delete(GitlabNotification{})
+		if dbNotif != nil {
+			g.followUp(*dbNotif, "Closed by @" + g.retrieveChatUser(issueEvent.User.Username) + ".")
+			g.updateRoot(*dbNotif, "closed", COLOR_SUCCESS)
+			robot.Store().Unscoped().Delete(dbNotif)
 		}
 		return
 	}
+	if dbNotif != nil && notif.AssignedUser != "" && dbNotif.AssignedUser != notif.AssignedUser {
+		g.followUp(*dbNotif, "Assigned to @" + g.retrieveChatUser(issueEvent.Assignee.Username) + ".")
+	}
 	if issueEvent.ObjectAttributes.State != "opened" {
 		return
 	}
 
-	notif.Message = fmt.Sprintf(
-		"**Issue** on project [%s](%s) from @%s, [click here](%s), title: \n> %s",
-		issueEvent.Project.PathWithNamespace,
-		issueEvent.ObjectAttributes.URL,
-		g.retrieveChatUser(issueEvent.User.Username),
-		issueEvent.ObjectAttributes.URL,
-		issueEvent.ObjectAttributes.Title,
-	)
+	data := TemplateData{
+		Event: ISSUE_EVENT_NAME,
+		Project: TemplateProject{
+			Name: issueEvent.Project.Name,
+			Path: issueEvent.Project.PathWithNamespace,
+			Url: issueEvent.Project.Homepage,
+		},
+		Author: g.retrieveChatUser(issueEvent.User.Username),
+		Assignee: g.retrieveChatUser(issueEvent.Assignee.Username),
+		Title: issueEvent.ObjectAttributes.Title,
+		Url: issueEvent.ObjectAttributes.URL,
+		Iid: issueEvent.ObjectAttributes.IID,
+		State: issueEvent.ObjectAttributes.State,
+		Labels: labelsFromWebhook(webhook),
+	}
+	notif.Message = g.renderTemplate(ISSUE_EVENT_NAME, notif.ChannelName, data)
+	notif.Card = newCard(data)
 
 	g.notifyWithSave(notif)
 }
@@ -142,49 +253,72 @@ func (g GitlabApp) notifyMergeRequest(webhook []byte) {
 	if g.isFilteredRepo(mergeEvent.Project.PathWithNamespace) {
 		return
 	}
+	g.recordMergeRequestTransition(mergeEvent)
+	updateMergeStatus(mergeEvent)
+	g.recordActivity(mergeEvent.ObjectAttributes.TargetProjectID, mergeEvent.ObjectAttributes.IID, MERGE_REQUEST_EVENT_NAME)
 	notif := &GitlabNotification{
 		ProjectID: mergeEvent.ObjectAttributes.TargetProjectID,
 		ProjectName: mergeEvent.Project.Name,
-		GroupName: mergeEvent.Project.Namespace,
+		GroupName: namespaceFromPath(mergeEvent.Project.PathWithNamespace),
+		ProjectPath: mergeEvent.Project.PathWithNamespace,
 		Type: MERGE_REQUEST_EVENT_NAME,
 		ObjectId: mergeEvent.ObjectAttributes.ID,
-		ChannelName: g.conf.GitlabNotifyChannel,
+		ObjectIid: mergeEvent.ObjectAttributes.IID,
+		ChannelName: g.channelForProject(mergeEvent.Project.PathWithNamespace),
 		WebUrl: mergeEvent.ObjectAttributes.URL,
 		ProjectUrl: mergeEvent.Project.Homepage,
 		AssignedUser: g.retrieveGitlabUser(mergeEvent.Assignee.Username),
+		SourceBranch: mergeEvent.ObjectAttributes.SourceBranch,
 	}
 	state := mergeEvent.ObjectAttributes.State
+	dbNotif := g.findQueuedNotif(notif.ProjectID, notif.ObjectId, notif.Type)
 	if state == "closed" || state == "merged" {
-		var count int
-		robot.Store().Model(&GitlabNotification{}).Where(notif).Count(&count)
-		if count > 0 {
-			robot.Store().Unscoped().Where(&notif).Delete(GitlabNotification{})
+		if dbNotif != nil {
+			g.followUp(*dbNotif, strings.Title(state) + " by @" + g.retrieveChatUser(mergeEvent.User.Username) + ".")
+			g.updateRoot(*dbNotif, state, COLOR_SUCCESS)
+			robot.Store().Unscoped().Delete(dbNotif)
 		}
+		g.closeBridges(notif.ProjectID, notif.ObjectIid, state)
 		return
 	}
+	if dbNotif != nil {
+		g.followUpMergeRequest(*dbNotif, mergeEvent)
+	}
 	if mergeEvent.Assignee.Username != "" || mergeEvent.ObjectAttributes.State != "opened" {
 		return
 	}
-	notif.Message = fmt.Sprintf(
-		"**Merge request** on project [%s](%s) from @%s, [click here](%s), title: \n> %s",
-		mergeEvent.ObjectAttributes.Target.PathWithNamespace,
-		mergeEvent.ObjectAttributes.URL,
-		g.retrieveChatUser(mergeEvent.User.Username),
-		mergeEvent.ObjectAttributes.URL,
-		mergeEvent.ObjectAttributes.Title,
-	)
+	data := TemplateData{
+		Event: MERGE_REQUEST_EVENT_NAME,
+		Project: TemplateProject{
+			Name: mergeEvent.Project.Name,
+			Path: mergeEvent.ObjectAttributes.Target.PathWithNamespace,
+			Url: mergeEvent.Project.Homepage,
+		},
+		Author: g.retrieveChatUser(mergeEvent.User.Username),
+		Assignee: g.retrieveChatUser(mergeEvent.Assignee.Username),
+		Title: mergeEvent.ObjectAttributes.Title,
+		Url: mergeEvent.ObjectAttributes.URL,
+		Iid: mergeEvent.ObjectAttributes.IID,
+		State: mergeEvent.ObjectAttributes.State,
+		Labels: labelsFromWebhook(webhook),
+		SourceBranch: mergeEvent.ObjectAttributes.SourceBranch,
+		TargetBranch: mergeEvent.ObjectAttributes.TargetBranch,
+	}
+	notif.Message = g.renderTemplate(MERGE_REQUEST_EVENT_NAME, notif.ChannelName, data)
+	notif.Card = newCard(data)
 	g.notifyWithSave(notif)
 }
 func (g GitlabApp) notifyWithSave(notif *GitlabNotification) {
-	if g.isFilteredRepo(notif.ProjectName) {
+	if g.isFilteredRepo(notif.ProjectPath) {
 		return
 	}
+	// notification can be in another channel when the team owning the
+	// project changed its channel
 	var dbNotif GitlabNotification
 	robot.Store().Model(&GitlabNotification{}).Where(&GitlabNotification{
 		ProjectID: notif.ProjectID,
 		ObjectId: notif.ObjectId,
 		Type: notif.Type,
-		ChannelName: notif.ChannelName,
 	}).First(&dbNotif)
 	if dbNotif.ID == 0 {
 		robot.Store().Create(notif)
@@ -193,19 +327,33 @@ func (g GitlabApp) notifyWithSave(notif *GitlabNotification) {
 		robot.Store().Save(dbNotif)
 		return
 	}
-	g.notify(notif)
+	g.notifyRoot(notif)
+}
+func (g GitlabApp) followUpMergeRequest(notif GitlabNotification, mergeEvent gitlab.MergeEvent) {
+	user := g.retrieveChatUser(mergeEvent.User.Username)
+	switch mergeEvent.ObjectAttributes.Action {
+	case "approved":
+		g.followUp(notif, "Approved by @" + user + ".")
+		return
+	case "unapproved":
+		g.followUp(notif, "Approval removed by @" + user + ".")
+		return
+	}
+	assignee := g.retrieveGitlabUser(mergeEvent.Assignee.Username)
+	if assignee != "" && assignee != notif.AssignedUser {
+		g.followUp(notif, "Assigned to @" + g.retrieveChatUser(mergeEvent.Assignee.Username) + ".")
+		notif.AssignedUser = assignee
+		robot.Store().Save(&notif)
+	}
 }
 func (g GitlabApp) notify(notif *GitlabNotification) {
 	if notif.AssignedUser != "" {
 		return
 	}
-	users, err := g.userWithPermissionFromProjects(*notif)
+	mentions, err := g.mentions(*notif)
 	if err != nil {
 		robot.Logger().Error("Error when notifying: %s", err.Error())
 		return
 	}
-	message := "@" + strings.Join(users, " @") + " : " + notif.Message
-	robot.SendMessages(robot.Envelop{
-		ChannelName: notif.ChannelName,
-	}, message)
+	g.sendMessage(notif.ChannelName, mentions, notif.Message, notif.Card)
 }
\ No newline at end of file